@@ -1,203 +1,130 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"strings"
-	"time"
-
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-	"github.com/go-chi/cors"
-	"github.com/go-chi/httprate"
-	"github.com/go-redis/redis/v8"
-	"github.com/joho/godotenv"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"gorm.io/driver/postgres"
+	"strconv"
+
+	"github.com/rs/zerolog"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 
-	"github.com/thankyoudiscord/api/pkg/auth"
-	"github.com/thankyoudiscord/api/pkg/cache"
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/config"
 	"github.com/thankyoudiscord/api/pkg/database"
-	"github.com/thankyoudiscord/api/pkg/protos"
+	"github.com/thankyoudiscord/api/pkg/logging"
 	"github.com/thankyoudiscord/api/pkg/routes"
+	"github.com/thankyoudiscord/api/pkg/tracing"
 )
 
-const DEFAULT_ADDR = ":3000"
-
-var (
-	redisClient *redis.Client
-
-	ADDR,
-	CLIENT_ID,
-	CLIENT_SECRET,
-	REDIRECT_URI,
-	REDIS_HOST,
-	REDIS_PORT,
-	POSTGRES_HOST,
-	POSTGRES_PORT,
-	POSTGRES_USER,
-	POSTGRES_PASSWORD,
-	POSTGRES_DB,
-	BANNER_GRPC_ADDR string
-
-	REQUIRED_ENV = []string{
-		"CLIENT_ID",
-		"CLIENT_SECRET",
-		"REDIRECT_URI",
-		"REDIS_HOST",
-		"REDIS_PORT",
-		"POSTGRES_HOST",
-		"POSTGRES_PORT",
-		"POSTGRES_USER",
-		"POSTGRES_PASSWORD",
-		"POSTGRES_DB",
-		"BANNER_GRPC_ADDR",
+func main() {
+	conf, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		logging.Default().Fatal().Err(err).Msg("invalid config")
 	}
-)
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		panic(err)
+	logger, err := logging.New(conf)
+	if err != nil {
+		logging.Default().Fatal().Err(err).Msg("failed to set up logging")
 	}
 
-	CLIENT_ID = os.Getenv("CLIENT_ID")
-	CLIENT_SECRET = os.Getenv("CLIENT_SECRET")
-	REDIRECT_URI = os.Getenv("REDIRECT_URI")
-	REDIS_HOST = os.Getenv("REDIS_HOST")
-	REDIS_PORT = os.Getenv("REDIS_PORT")
-	POSTGRES_HOST = os.Getenv("POSTGRES_HOST")
-	POSTGRES_PORT = os.Getenv("POSTGRES_PORT")
-	POSTGRES_USER = os.Getenv("POSTGRES_USER")
-	POSTGRES_PASSWORD = os.Getenv("POSTGRES_PASSWORD")
-	POSTGRES_DB = os.Getenv("POSTGRES_DB")
-	BANNER_GRPC_ADDR = os.Getenv("BANNER_GRPC_ADDR")
-
-	var ok bool
-	ADDR, ok = os.LookupEnv("ADDR")
-	if !ok {
-		ADDR = DEFAULT_ADDR
-	}
+	// for work done outside of requests
+	ctx := logging.WithLogger(context.Background(), &logger)
 
-	missing := checkenv(REQUIRED_ENV)
+	shutdownTracing, err := tracing.Init(conf)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to set up tracing")
+	}
 
-	if len(missing) != 0 {
-		log.Fatalf(
-			"missing %v in env",
-			strings.Join(missing, ", "),
-		)
+	a, err := app.New(conf, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to set up app")
 	}
 
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: REDIS_HOST + ":" + REDIS_PORT,
-	})
-	auth.InitAuthManager(redisClient)
-	cache.InitBannerCache(redisClient)
-
-	pgConnUrl := url.URL{
-		User:   url.UserPassword(POSTGRES_USER, POSTGRES_PASSWORD),
-		Scheme: "postgres",
-		Host:   POSTGRES_HOST + ":" + POSTGRES_PORT,
-		Path:   POSTGRES_DB,
-		RawQuery: url.Values{
-			"sslmode":  {"disable"},
-			"TimeZone": {"America/New_York"},
-		}.Encode(),
+	if len(args) > 0 && args[0] == "migrate" {
+		if a.DB == nil {
+			logger.Fatal().Msg("migrations need DATABASE_STORE=postgres")
+		}
+
+		runMigrate(&logger, a.DB.WithContext(ctx), args[1:])
+		a.Close()
+		return
 	}
 
-	d, err := gorm.Open(postgres.Open(pgConnUrl.String()), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
-	})
-	if err != nil {
-		log.Fatalf("failed to connect to postgres: %v\n", err)
+	fields := map[string]interface{}{}
+	for k, v := range conf.Values() {
+		fields[k] = v
 	}
 
-	database.InitDatabase(d)
-}
+	logger.Info().Fields(fields).Msg("loaded config")
 
-func main() {
-	bannerGRPCConn, err := grpc.Dial(
-		BANNER_GRPC_ADDR,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to dial banner grpc server: %v\n", err)
-		os.Exit(1)
+	// the memory store has no schema
+	if conf.MigrateOnStartup && a.DB != nil {
+		if err := database.MigrateUp(a.DB.WithContext(ctx)); err != nil {
+			logger.Fatal().Err(err).Msg("failed to migrate database")
+		}
 	}
 
-	bannerGenClient := protos.NewBannerClient(bannerGRPCConn)
-
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	if err := a.Auth.SeedAdmins(ctx, conf.AdminUserIDs); err != nil {
+		logger.Fatal().Err(err).Msg("failed to seed admins")
+	}
 
-	r.Use(httprate.Limit(
-		15,
-		10*time.Second,
-		httprate.WithKeyFuncs(httprate.KeyByEndpoint, httprate.KeyByIP),
-	))
+	a.Router = routes.NewRouter(a)
 
-	ALLOWED_ORIGINS := []string{"*"}
+	shutdownCtx, skipDelay, stop := app.ShutdownSignals(&logger)
+	runErr := a.Run(shutdownCtx, skipDelay)
+	stop()
 
-	if os.Getenv("APP_ENV") == "production" {
-		ALLOWED_ORIGINS = []string{
-			"localhost:*",
-			"*.wah.wtf",
-			"*.thankyoudiscord.com",
-		}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error().Err(err).Msg("failed to flush traces")
 	}
 
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   ALLOWED_ORIGINS,
-		AllowCredentials: true,
-	}))
-
-	r.Mount("/", routes.AuthRoutes{}.Routes())
+	if runErr != nil {
+		logger.Fatal().Err(runErr).Msg("server failed")
+	}
+}
 
-	r.Mount("/banner", routes.NewBannerRoutes(bannerGenClient).Routes())
-	r.Mount("/users", routes.UserRoutes{}.Routes())
+// runMigrate implements `migrate [up|down [steps]|status]`.
+func runMigrate(logger *zerolog.Logger, db *gorm.DB, args []string) {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
 
-	r.Get("/stats", func(w http.ResponseWriter, r *http.Request) {
-		db := database.GetDatabase()
+	switch cmd {
+	case "up":
+		if err := database.MigrateUp(db); err != nil {
+			logger.Fatal().Err(err).Msg("failed to migrate database")
+		}
 
-		var count int64
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				logger.Fatal().Str("steps", args[1]).Msg("invalid number of steps")
+			}
 
-		res := db.Model(&database.Signature{}).Count(&count)
-		if res.Error != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			steps = n
 		}
 
-		resp := map[string]int64{
-			"signatures": count,
+		if err := database.MigrateDown(db, steps); err != nil {
+			logger.Fatal().Err(err).Msg("failed to migrate database")
 		}
 
-		bytes, err := json.Marshal(resp)
+	case "status":
+		version, pending, err := database.MigrationStatus(db)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			logger.Fatal().Err(err).Msg("failed to get migration status")
 		}
 
-		w.Write(bytes)
-	})
-
-	if err := http.ListenAndServe(ADDR, r); err != nil {
-		log.Fatalf("failed to start server: %v\n", err)
-	}
-}
-
-func checkenv(keys []string) []string {
-	var missing []string
-	for _, key := range keys {
-		if val, ok := os.LookupEnv(key); len(val) == 0 || !ok {
-			missing = append(missing, key)
+		fmt.Printf("schema version: %v\n", version)
+		for _, m := range pending {
+			fmt.Printf("pending: %v_%v\n", m.Version, m.Name)
 		}
-	}
 
-	return missing
+	default:
+		fmt.Fprintf(os.Stderr, "usage: %v migrate [up|down [steps]|status]\n", os.Args[0])
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,335 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-redis/redis/extra/redisotel/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/cache"
+	"github.com/thankyoudiscord/api/pkg/config"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/metrics"
+	"github.com/thankyoudiscord/api/pkg/models"
+	"github.com/thankyoudiscord/api/pkg/protos"
+	"github.com/thankyoudiscord/api/pkg/tracing"
+)
+
+// App owns everything a running instance of the API needs. Handlers get their
+// dependencies from here rather than from package globals, so several
+// instances can live in one process.
+type App struct {
+	Config *config.Config
+	Logger zerolog.Logger
+
+	// for outgoing requests, traced as children of the request making them
+	HTTPClient *http.Client
+	Discord    *models.Discord
+
+	// nil when DATABASE_STORE is memory
+	DB    *gorm.DB
+	Redis *redis.Client
+
+	BannerConn   *grpc.ClientConn
+	BannerClient protos.BannerClient
+	BannerCache  cache.BannerCache
+
+	Auth       *auth.AuthManager
+	Users      database.UserStore
+	Signatures database.SignatureStore
+	Bans       database.BanStore
+	Audit      database.AuditStore
+	Moderation database.ModerationStore
+
+	// set by the caller once the routes are built, see routes.NewRouter
+	Router chi.Router
+
+	// set to 1 once shutdown starts, see ShuttingDown
+	shuttingDown int32
+}
+
+// New connects to the app's dependencies. Redis and the banner generator are
+// connected lazily, postgres (unless DATABASE_STORE is memory) is connected
+// right away.
+func New(conf *config.Config, rootLogger zerolog.Logger) (*App, error) {
+	a := &App{
+		Config:     conf,
+		Logger:     rootLogger,
+		HTTPClient: tracing.NewHTTPClient(),
+	}
+
+	a.Discord = models.NewDiscord(conf.DiscordAPIURL, a.HTTPClient)
+
+	a.Redis = redis.NewClient(&redis.Options{
+		Addr: conf.RedisAddr(),
+	})
+	a.Redis.AddHook(redisotel.NewTracingHook())
+
+	store, err := a.newStore()
+	if err != nil {
+		return nil, err
+	}
+
+	a.Users = store
+	a.Signatures = store
+	a.Bans = store
+	a.Audit = store
+	a.Moderation = store
+
+	sessionStore, err := a.newSessionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	oauthConf := &oauth2.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  conf.RedirectURI,
+		Scopes:       []string{"identify"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  a.Discord.APIURL + "/oauth2/authorize",
+			TokenURL: a.Discord.APIURL + "/oauth2/token",
+		},
+	}
+
+	a.Auth = auth.NewAuthManager(
+		sessionStore,
+		oauthConf,
+		store,
+		a.Discord,
+		conf.UserCacheTTL,
+		conf.SessionMaxLifetime,
+		conf.BansBlockLogin,
+	)
+
+	a.BannerCache = cache.NewBannerCache(a.Redis)
+
+	a.BannerConn, err = grpc.Dial(
+		conf.BannerGRPCAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	a.BannerClient = protos.NewBannerClient(a.BannerConn)
+
+	return a, nil
+}
+
+func (a *App) newStore() (database.Store, error) {
+	if a.Config.DatabaseStore == "memory" {
+		return database.NewMemoryStore(), nil
+	}
+
+	db, err := gorm.Open(postgres.Open(a.Config.PostgresURL()), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		return nil, err
+	}
+
+	a.DB = db
+	return database.NewPostgresStore(db), nil
+}
+
+func (a *App) newSessionStore() (auth.SessionStore, error) {
+	if a.Config.SessionStore == "memory" {
+		return auth.NewMemorySessionStore(), nil
+	}
+
+	keys, err := auth.ParseSessionKeys(a.Config.SessionEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionCipher, err := auth.NewSessionCipher(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewRedisSessionStore(a.Redis, sessionCipher), nil
+}
+
+// ShutdownSignals returns a context that is canceled on the first SIGINT or
+// SIGTERM, and a channel that is closed on the second, which makes Run skip
+// the shutdown delay. stop stops listening for the signals.
+func ShutdownSignals(logger *zerolog.Logger) (ctx context.Context, skipDelay <-chan struct{}, stop func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	second := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case s := <-sig:
+			logger.Info().Str("signal", s.String()).Msg("got shutdown signal")
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-sig:
+			close(second)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return ctx, second, func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			close(done)
+			cancel()
+		})
+	}
+}
+
+// Run listens on the configured address and the internal metrics address and
+// serves them, see Serve.
+func (a *App) Run(ctx context.Context, skipDelay <-chan struct{}) error {
+	lis, err := net.Listen("tcp", a.Config.Addr)
+	if err != nil {
+		a.Close()
+		return err
+	}
+
+	metricsLis, err := net.Listen("tcp", a.Config.MetricsAddr)
+	if err != nil {
+		lis.Close()
+		a.Close()
+		return err
+	}
+
+	return a.Serve(ctx, skipDelay, lis, metricsLis)
+}
+
+// Serve serves the router on lis, and metrics on metricsLis, until a server
+// fails or ctx is done. It then keeps serving for the shutdown delay, or until
+// skipDelay is closed, so load balancers notice the failing readiness check,
+// drains in-flight requests, stops serving metrics and closes the app's
+// connections.
+func (a *App) Serve(ctx context.Context, skipDelay <-chan struct{}, lis net.Listener, metricsLis net.Listener) error {
+	// e.g. TLS handshake errors and recovered panics from net/http
+	errorLog := log.New(a.Logger, "", 0)
+
+	srv := &http.Server{
+		Handler:           a.Router,
+		ReadTimeout:       a.Config.ReadTimeout,
+		ReadHeaderTimeout: a.Config.ReadTimeout,
+		WriteTimeout:      a.Config.WriteTimeout,
+		IdleTimeout:       a.Config.IdleTimeout,
+		ErrorLog:          errorLog,
+	}
+
+	metricsSrv := &http.Server{
+		Handler:           metrics.Handler(),
+		ReadHeaderTimeout: a.Config.ReadTimeout,
+		ErrorLog:          errorLog,
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- srv.Serve(lis)
+	}()
+	go func() {
+		serveErr <- metricsSrv.Serve(metricsLis)
+	}()
+
+	select {
+	case err := <-serveErr:
+		srv.Close()
+		metricsSrv.Close()
+		a.Close()
+		return err
+
+	case <-ctx.Done():
+		a.BeginShutdown()
+		a.Logger.Info().Dur("delay", a.Config.ShutdownDelay).Msg("shutting down")
+	}
+
+	select {
+	case <-time.After(a.Config.ShutdownDelay):
+	case <-skipDelay:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Config.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		a.Logger.Error().Err(err).Msg("failed to drain requests")
+	}
+
+	// scrapes are cheap and can be retried, no need to drain them
+	metricsSrv.Close()
+
+	if closeErr := a.Close(); closeErr != nil {
+		a.Logger.Error().Err(closeErr).Msg("failed to close connections")
+	}
+
+	return err
+}
+
+// Close closes the app's connections to the banner generator, redis and
+// postgres, in that order.
+func (a *App) Close() error {
+	var errs []string
+
+	if a.BannerConn != nil {
+		if err := a.BannerConn.Close(); err != nil {
+			errs = append(errs, "grpc: "+err.Error())
+		}
+	}
+
+	if a.Redis != nil {
+		if err := a.Redis.Close(); err != nil {
+			errs = append(errs, "redis: "+err.Error())
+		}
+	}
+
+	if a.DB != nil {
+		sqlDB, err := a.DB.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+
+		if err != nil {
+			errs = append(errs, "postgres: "+err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
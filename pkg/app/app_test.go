@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+
+	"github.com/thankyoudiscord/api/pkg/config"
+)
+
+// testServer is an app serving on local listeners. GET /slow blocks until
+// release is closed.
+type testServer struct {
+	app        *App
+	addr       string
+	metricsURL string
+
+	entered chan struct{}
+	release chan struct{}
+
+	cancel    context.CancelFunc
+	skipDelay chan struct{}
+	done      chan error
+}
+
+func startTestServer(t *testing.T, delay time.Duration, timeout time.Duration) *testServer {
+	t.Helper()
+
+	ts := &testServer{
+		entered:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+		skipDelay: make(chan struct{}),
+		done:      make(chan error, 1),
+	}
+
+	r := chi.NewRouter()
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		ts.entered <- struct{}{}
+		<-ts.release
+		w.Write([]byte("done"))
+	})
+
+	ts.app = &App{
+		Config: &config.Config{
+			ShutdownDelay:   delay,
+			ShutdownTimeout: timeout,
+		},
+		Logger: zerolog.Nop(),
+		Router: r,
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricsLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.addr = lis.Addr().String()
+	ts.metricsURL = "http://" + metricsLis.Addr().String() + "/metrics"
+
+	var ctx context.Context
+	ctx, ts.cancel = context.WithCancel(context.Background())
+	t.Cleanup(ts.cancel)
+
+	go func() {
+		ts.done <- ts.app.Serve(ctx, ts.skipDelay, lis, metricsLis)
+	}()
+
+	return ts
+}
+
+// get makes a request on a new connection, so it can't reuse one the server
+// accepted before shutting down.
+func get(url string) (int, string, error) {
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		Timeout:   5 * time.Second,
+	}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	return res.StatusCode, string(body), err
+}
+
+// getSlow starts a GET /slow and waits for it to reach the handler.
+func (ts *testServer) getSlow(t *testing.T) <-chan string {
+	t.Helper()
+
+	body := make(chan string, 1)
+	go func() {
+		_, b, err := get("http://" + ts.addr + "/slow")
+		if err != nil {
+			b = err.Error()
+		}
+
+		body <- b
+	}()
+
+	select {
+	case <-ts.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the handler")
+	}
+
+	return body
+}
+
+func (ts *testServer) wait(t *testing.T) error {
+	t.Helper()
+
+	select {
+	case err := <-ts.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+		return nil
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	ts := startTestServer(t, 200*time.Millisecond, 5*time.Second)
+
+	if status, _, err := get(ts.metricsURL); err != nil || status != http.StatusOK {
+		t.Fatalf("metrics got %v, %v", status, err)
+	}
+
+	slow := ts.getSlow(t)
+
+	start := time.Now()
+	ts.cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ts.app.ShuttingDown() {
+		if time.Now().After(deadline) {
+			t.Fatal("app isn't shutting down after cancel")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// no longer ready, but still serving during the delay
+	if status, body, err := get("http://" + ts.addr + "/ping"); err != nil || body != "pong" {
+		t.Fatalf("got %v %q, %v during the shutdown delay", status, body, err)
+	}
+
+	// once the delay is over nothing new is accepted, while the in-flight
+	// request keeps going
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", ts.addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+
+		if time.Now().After(deadline) {
+			t.Fatal("still accepting connections after the shutdown delay")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("stopped accepting connections after %v, before the shutdown delay", elapsed)
+	}
+
+	// metrics stay up while draining
+	if status, _, err := get(ts.metricsURL); err != nil || status != http.StatusOK {
+		t.Errorf("metrics got %v, %v while draining", status, err)
+	}
+
+	select {
+	case err := <-ts.done:
+		t.Fatalf("Serve returned %v before the in-flight request finished", err)
+	default:
+	}
+
+	close(ts.release)
+
+	if body := <-slow; body != "done" {
+		t.Errorf("in-flight request got %q, want it to finish", body)
+	}
+
+	if err := ts.wait(t); err != nil {
+		t.Errorf("Serve = %v, want nil", err)
+	}
+
+	if _, _, err := get(ts.metricsURL); err == nil {
+		t.Error("metrics still served after shutdown")
+	}
+}
+
+func TestServeSkipDelay(t *testing.T) {
+	ts := startTestServer(t, time.Hour, 5*time.Second)
+
+	ts.cancel()
+	close(ts.skipDelay)
+
+	if err := ts.wait(t); err != nil {
+		t.Errorf("Serve = %v, want nil", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	ts := startTestServer(t, 0, 100*time.Millisecond)
+	defer close(ts.release)
+
+	ts.getSlow(t)
+	ts.cancel()
+
+	if err := ts.wait(t); err != context.DeadlineExceeded {
+		t.Errorf("Serve = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestServeFailure(t *testing.T) {
+	app := &App{
+		Config: &config.Config{ShutdownTimeout: time.Second},
+		Logger: zerolog.Nop(),
+		Router: chi.NewRouter(),
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricsLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// closing a listener makes its server fail
+	lis.Close()
+
+	err = app.Serve(context.Background(), nil, lis, metricsLis)
+	if err == nil || err == http.ErrServerClosed {
+		t.Fatalf("Serve = %v, want the listener's error", err)
+	}
+
+	if _, err := net.Dial("tcp", metricsLis.Addr().String()); err == nil {
+		t.Error("metrics listener still open after a server failed")
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// how long each dependency gets to answer a readiness check
+const READINESS_CHECK_TIMEOUT = time.Second * 2
+
+// ShuttingDown reports whether the app got a shutdown signal. It keeps serving
+// requests while draining but shouldn't get new traffic.
+func (a *App) ShuttingDown() bool {
+	return atomic.LoadInt32(&a.shuttingDown) == 1
+}
+
+// BeginShutdown marks the app as shutting down, readiness checks fail from then
+// on.
+func (a *App) BeginShutdown() {
+	atomic.StoreInt32(&a.shuttingDown, 1)
+}
+
+// CheckDependencies pings redis, postgres (if used) and the banner generator
+// concurrently and returns the error for each, nil meaning healthy.
+func (a *App) CheckDependencies(ctx context.Context) map[string]error {
+	checks := map[string]func(context.Context) error{
+		"redis": func(ctx context.Context) error {
+			return a.Redis.Ping(ctx).Err()
+		},
+		"banner_grpc": func(ctx context.Context) error {
+			return waitForReady(ctx, a.BannerConn)
+		},
+	}
+
+	if a.DB != nil {
+		checks["postgres"] = func(ctx context.Context) error {
+			sqlDB, err := a.DB.DB()
+			if err != nil {
+				return err
+			}
+
+			return sqlDB.PingContext(ctx)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]error{}
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, READINESS_CHECK_TIMEOUT)
+			defer cancel()
+
+			err := check(ctx)
+
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, check)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// waitForReady waits for the connection to become ready, connecting it if it
+// is idle. grpc connects lazily, so an unused connection starts out idle.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.Shutdown:
+			return fmt.Errorf("connection is shut down")
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection is %v", state)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+)
+
+const API_TOKEN_PREFIX = "tyd_"
+
+// how often a token's last use is written back to the database
+const API_TOKEN_LAST_USED_INTERVAL = time.Minute
+
+// Scopes an API token can be granted. Cookie sessions implicitly have all of
+// them.
+const (
+	SCOPE_USER_READ       = "user:read"
+	SCOPE_SIGNATURE_WRITE = "signature:write"
+
+	// lets a token use the admin API, limited by the user's role
+	SCOPE_ADMIN = "admin"
+)
+
+var API_TOKEN_SCOPES = []string{
+	SCOPE_USER_READ,
+	SCOPE_SIGNATURE_WRITE,
+	SCOPE_ADMIN,
+}
+
+var ErrUnknownScope = errors.New("unknown scope")
+var ErrExpiryInPast = errors.New("expiry is in the past")
+
+func hashAPIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func validScope(scope string) bool {
+	for _, s := range API_TOKEN_SCOPES {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateAPIToken issues a new token for the user and returns it along with its
+// database record. The token can't be recovered later. Fails with
+// ErrExpiryInPast unless expiresAt is nil or in the future.
+func (m AuthManager) CreateAPIToken(ctx context.Context, userID string, name string, scopes []string, expiresAt *time.Time) (string, *database.APIToken, error) {
+	for _, s := range scopes {
+		if !validScope(s) {
+			return "", nil, ErrUnknownScope
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, ErrExpiryInPast
+	}
+
+	raw, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := API_TOKEN_PREFIX + raw
+
+	record := database.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashAPIToken(token),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := m.APITokens.CreateAPIToken(ctx, &record); err != nil {
+		return "", nil, err
+	}
+
+	return token, &record, nil
+}
+
+func (m AuthManager) ListAPITokens(ctx context.Context, userID string) ([]database.APIToken, error) {
+	return m.APITokens.ListAPITokens(ctx, userID)
+}
+
+// RevokeAPIToken deletes one of the user's tokens. It returns false if the user
+// has no token with that ID.
+func (m AuthManager) RevokeAPIToken(ctx context.Context, userID string, id uint) (bool, error) {
+	return m.APITokens.RevokeAPIToken(ctx, userID, id)
+}
+
+// lookupAPIToken returns the live token matching the raw bearer token, or nil.
+func (m AuthManager) lookupAPIToken(ctx context.Context, token string) (*database.APIToken, error) {
+	if !strings.HasPrefix(token, API_TOKEN_PREFIX) {
+		return nil, nil
+	}
+
+	record, err := m.APITokens.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, nil
+	}
+
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > API_TOKEN_LAST_USED_INTERVAL {
+		if err := m.APITokens.TouchAPIToken(ctx, record.ID, now); err != nil {
+			logging.Ctx(ctx).Warn().Err(err).Msg("failed to update api token last use")
+		}
+	}
+
+	return record, nil
+}
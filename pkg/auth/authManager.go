@@ -1,103 +1,259 @@
 package auth
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"errors"
 	"fmt"
-	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/thankyoudiscord/api/pkg/database"
+	tyderrors "github.com/thankyoudiscord/api/pkg/errors"
+	"github.com/thankyoudiscord/api/pkg/models"
 )
 
 const SESSION_ID_COOKIE = "session_id"
 const SESSION_TTL = time.Hour * 24 * 7
+const SESSION_RENEW_INTERVAL = time.Hour * 24
 
-var mgrSingleton AuthManager
+const SESSION_REFRESH_LOCK_TTL = time.Second * 10
+const SESSION_REFRESH_WAIT = time.Second * 5
+const SESSION_REFRESH_POLL_INTERVAL = time.Millisecond * 100
 
 type AuthManager struct {
-	RedisClient *redis.Client
+	Store       SessionStore
+	OAuthConfig *oauth2.Config
+	Discord     *models.Discord
+
+	Users     database.UserStore
+	Bans      database.BanStore
+	Roles     database.RoleStore
+	APITokens database.APITokenStore
+
+	// how long a user's discord profile is trusted before the access token is
+	// checked against discord again, 0 disables the cache
+	UserCacheTTL time.Duration
+
+	// sessions are renewed while in use but never live longer than this after
+	// login, 0 means no limit
+	SessionMaxLifetime time.Duration
+
+	// banned users can't log in at all instead of only being kept from signing
+	BansBlockLogin bool
+
+	// waits between token revocation retries, time.Sleep if nil
+	sleep func(d time.Duration)
 }
 
 type Session struct {
 	RefreshToken string `json:"refresh_token"`
 	AccessToken  string `json:"access_token"`
 	UserID       string `json:"user_id"`
+
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func sessionRedisKey(sessionID string) string {
-	return "session:" + sessionID
+func sessionRefreshLockKey(sessionID string) string {
+	return "session_refresh_lock:" + sessionID
 }
 
-func (m AuthManager) CreateSession(s Session) (string, error) {
+// CreateSession stores a new session and returns its ID along with the time
+// it expires unless renewed.
+func (m AuthManager) CreateSession(ctx context.Context, s Session, info SessionInfo) (string, time.Time, error) {
 	sessionID := uuid.New().String()
 
-	var sess bytes.Buffer
-	enc := gob.NewEncoder(&sess)
+	now := time.Now()
+	s.CreatedAt = now
+	s.ExpiresAt = m.nextExpiry(now)
+
+	info.CreatedAt = now
+	info.LastSeen = now
 
-	err := enc.Encode(s)
+	err := m.Store.Create(ctx, sessionID, s, info)
 	if err != nil {
-		fmt.Printf("failed to encode session data for id=%v: %v\n", sessionID, err)
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	key := sessionRedisKey(sessionID)
-	status := m.RedisClient.SetEX(context.Background(), key, sess.Bytes(), SESSION_TTL)
-	if status.Err() != nil {
-		return "", status.Err()
+	return sessionID, s.ExpiresAt, nil
+}
+
+func (m AuthManager) DeleteSession(ctx context.Context, id string) error {
+	if err := m.Store.Delete(ctx, id); err != nil {
+		return err
 	}
 
-	return sessionID, nil
+	return m.DeleteCachedUser(ctx, id)
 }
 
-func (m AuthManager) DeleteSession(id string) error {
-	key := sessionRedisKey(id)
-	res := m.RedisClient.Del(context.Background(), key)
-
-	return res.Err()
+func (m AuthManager) GetSession(ctx context.Context, id string) (*Session, error) {
+	return m.Store.Get(ctx, id)
 }
 
-func (m AuthManager) GetSession(id string) (*Session, error) {
-	key := sessionRedisKey(id)
-	res := m.RedisClient.Get(context.Background(), key)
-	if res.Err() != nil {
-		if res.Err() == redis.Nil {
-			return nil, nil
+// RefreshSession exchanges the session's refresh token for a new access token
+// and stores the result under the same session ID.
+//
+// Refreshes of a single session are serialized with a lock in the store, since
+// discord rotates refresh tokens and a second refresh with the old token would
+// fail. Callers that lose the race pick up the token written by the winner.
+//
+// A nil session and nil error means the session no longer exists. Errors
+// wrapping tyderrors.OAuthRefreshFailed mean discord rejected the refresh.
+func (m AuthManager) RefreshSession(ctx context.Context, id string, stale *Session) (*Session, error) {
+	lockKey := sessionRefreshLockKey(id)
+
+	deadline := time.Now().Add(SESSION_REFRESH_WAIT)
+	var unlock func()
+	for {
+		var err error
+		unlock, err = m.Store.Lock(ctx, lockKey, SESSION_REFRESH_LOCK_TTL)
+		if err != nil {
+			return nil, err
 		}
 
-		return nil, res.Err()
+		if unlock != nil {
+			break
+		}
+
+		// someone else is refreshing this session, wait for them to finish
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for refresh of session id=%v", id)
+		}
+
+		time.Sleep(SESSION_REFRESH_POLL_INTERVAL)
+
+		current, err := m.GetSession(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.AccessToken != stale.AccessToken {
+			return current, nil
+		}
 	}
 
-	b, err := res.Bytes()
+	defer unlock()
+
+	current, err := m.GetSession(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	buf := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buf)
+	// the session was deleted or refreshed before we got the lock
+	if current == nil || current.AccessToken != stale.AccessToken {
+		return current, nil
+	}
 
-	var sess Session
-	err = dec.Decode(&sess)
+	tok, err := m.OAuthConfig.TokenSource(m.OAuthContext(ctx), &oauth2.Token{
+		RefreshToken: current.RefreshToken,
+	}).Token()
 	if err != nil {
-		fmt.Printf("failed to decode session id=%v: %v\n", id, err)
+		var re *oauth2.RetrieveError
+		if errors.As(err, &re) {
+			return nil, fmt.Errorf("%w: %v", tyderrors.OAuthRefreshFailed, err)
+		}
+
 		return nil, err
 	}
 
-	return &sess, nil
+	// a session deleted in the meantime (most likely a logout) is not
+	// resurrected, Update returns nil for it
+	return m.Store.Update(ctx, id, func(s *Session) {
+		s.AccessToken = tok.AccessToken
+		if tok.RefreshToken != "" {
+			s.RefreshToken = tok.RefreshToken
+		}
+	})
+}
+
+// OAuthContext makes oauth2 calls made with ctx go through the discord HTTP
+// client.
+func (m AuthManager) OAuthContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, m.Discord.HTTPClient)
+}
+
+// nextExpiry returns when a session created at createdAt expires if it is
+// renewed now.
+func (m AuthManager) nextExpiry(createdAt time.Time) time.Time {
+	expiresAt := time.Now().Add(SESSION_TTL)
+
+	if m.SessionMaxLifetime > 0 {
+		max := createdAt.Add(m.SessionMaxLifetime)
+		if max.Before(expiresAt) {
+			return max
+		}
+	}
+
+	return expiresAt
 }
 
-var initOnce sync.Once
+// ShouldRenew reports whether the session was last renewed more than
+// SESSION_RENEW_INTERVAL ago and can still be extended. Renewing at most once
+// per interval keeps the middleware from writing to the store on every
+// request.
+func (m AuthManager) ShouldRenew(s *Session) bool {
+	// sessions from before expiry was tracked
+	if s.CreatedAt.IsZero() || s.ExpiresAt.IsZero() {
+		return true
+	}
+
+	if time.Until(s.ExpiresAt) > SESSION_TTL-SESSION_RENEW_INTERVAL {
+		return false
+	}
+
+	return m.nextExpiry(s.CreatedAt).After(s.ExpiresAt)
+}
 
-func InitAuthManager(r *redis.Client) {
-	initOnce.Do(func() {
-		mgrSingleton = AuthManager{
-			RedisClient: r,
+// RenewSession pushes the session's expiry out by SESSION_TTL, capped at
+// SessionMaxLifetime after login. It returns the new expiry, or a zero time if
+// the session no longer exists.
+func (m AuthManager) RenewSession(ctx context.Context, id string) (time.Time, error) {
+	renewed, err := m.Store.Update(ctx, id, func(s *Session) {
+		if s.CreatedAt.IsZero() {
+			s.CreatedAt = time.Now()
 		}
+
+		s.ExpiresAt = m.nextExpiry(s.CreatedAt)
 	})
+
+	if err != nil || renewed == nil {
+		return time.Time{}, err
+	}
+
+	return renewed.ExpiresAt, nil
+}
+
+// IsLoginBlocked reports whether the user is banned and bans block logins.
+func (m AuthManager) IsLoginBlocked(ctx context.Context, userID string) (bool, error) {
+	if !m.BansBlockLogin {
+		return false, nil
+	}
+
+	ban, err := m.Bans.GetActiveBan(ctx, userID)
+	return ban != nil, err
 }
 
-func GetManager() AuthManager {
-	return mgrSingleton
+func NewAuthManager(
+	store SessionStore,
+	oc *oauth2.Config,
+	db database.Store,
+	discord *models.Discord,
+	userCacheTTL time.Duration,
+	sessionMaxLifetime time.Duration,
+	bansBlockLogin bool,
+) *AuthManager {
+	return &AuthManager{
+		Store:              store,
+		OAuthConfig:        oc,
+		Discord:            discord,
+		Users:              db,
+		Bans:               db,
+		Roles:              db,
+		APITokens:          db,
+		UserCacheTTL:       userCacheTTL,
+		SessionMaxLifetime: sessionMaxLifetime,
+		BansBlockLogin:     bansBlockLogin,
+	}
 }
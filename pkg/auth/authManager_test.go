@@ -0,0 +1,557 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	tyderrors "github.com/thankyoudiscord/api/pkg/errors"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+// fakeDiscord stubs the discord endpoints the auth manager calls: the current
+// user, the token exchange and token revocation.
+type fakeDiscord struct {
+	mu sync.Mutex
+
+	// access tokens /users/@me accepts
+	valid map[string]bool
+	// refresh tokens the token endpoint accepts, and the access token each one
+	// is exchanged for
+	refresh map[string]string
+	// statuses of successive revocations, 200 once they run out
+	revokeStatuses []int
+	// called with every revoked token before responding
+	onRevoke func(token string)
+	// when set, token exchanges wait for it to be closed
+	refreshGate chan struct{}
+
+	userCalls    int
+	refreshCalls int
+	revoked      []string
+}
+
+func newFakeDiscord(t *testing.T) (*fakeDiscord, *httptest.Server) {
+	t.Helper()
+
+	fd := &fakeDiscord{
+		valid:   map[string]bool{},
+		refresh: map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v9/users/@me", fd.getUser)
+	mux.HandleFunc("/oauth2/token", fd.exchange)
+	mux.HandleFunc("/oauth2/token/revoke", fd.revoke)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return fd, srv
+}
+
+func (fd *fakeDiscord) getUser(w http.ResponseWriter, r *http.Request) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.userCalls++
+
+	token, _ := bearerToken(r)
+	if !fd.valid[token] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(testUser)
+}
+
+func (fd *fakeDiscord) exchange(w http.ResponseWriter, r *http.Request) {
+	fd.mu.Lock()
+	gate := fd.refreshGate
+	fd.mu.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.refreshCalls++
+
+	refreshToken := r.FormValue("refresh_token")
+	access, ok := fd.refresh[refreshToken]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	// discord rotates refresh tokens
+	delete(fd.refresh, refreshToken)
+	fd.valid[access] = true
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  access,
+		"refresh_token": refreshToken + "-rotated",
+		"token_type":    "Bearer",
+		"expires_in":    604800,
+	})
+}
+
+func (fd *fakeDiscord) revoke(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+
+	fd.mu.Lock()
+	onRevoke := fd.onRevoke
+	fd.mu.Unlock()
+
+	if onRevoke != nil {
+		onRevoke(token)
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.revoked = append(fd.revoked, token)
+	if len(fd.revokeStatuses) != 0 {
+		w.WriteHeader(fd.revokeStatuses[0])
+		fd.revokeStatuses = fd.revokeStatuses[1:]
+	}
+}
+
+func (fd *fakeDiscord) revokedTokens() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	return append([]string{}, fd.revoked...)
+}
+
+func (fd *fakeDiscord) calls() (user int, refresh int) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	return fd.userCalls, fd.refreshCalls
+}
+
+// useDiscord points the manager at the stub.
+func useDiscord(m *AuthManager, srv *httptest.Server) {
+	m.Discord = models.NewDiscord(srv.URL, srv.Client())
+	m.OAuthConfig = &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint: oauth2.Endpoint{
+			TokenURL: srv.URL + "/oauth2/token",
+			// autodetection would send failed exchanges twice
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+	}
+}
+
+// recordingStore counts the session updates made through it.
+type recordingStore struct {
+	SessionStore
+
+	mu      sync.Mutex
+	updates int
+}
+
+func (rs *recordingStore) Update(ctx context.Context, id string, fn func(s *Session)) (*Session, error) {
+	rs.mu.Lock()
+	rs.updates++
+	rs.mu.Unlock()
+
+	return rs.SessionStore.Update(ctx, id, fn)
+}
+
+func (rs *recordingStore) updateCount() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.updates
+}
+
+func createTestSession(t *testing.T, m *AuthManager, access string, refresh string) string {
+	t.Helper()
+
+	id, _, err := m.CreateSession(context.Background(), Session{
+		UserID:       TEST_USER_ID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+	}, SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// serveSession sends a request with the session's cookie through h.
+func serveSession(h http.Handler, sessionID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: SESSION_ID_COOKIE, Value: sessionID})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func getTestSession(t *testing.T, m *AuthManager, id string) *Session {
+	t.Helper()
+
+	s, err := m.GetSession(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestAuthenticatedRefreshesExpiredToken(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	store := &recordingStore{SessionStore: m.Store}
+	m.Store = store
+
+	fd.refresh["refresh"] = "fresh"
+	id := createTestSession(t, m, "stale", "refresh")
+
+	var got *http.Request
+	w := serveSession(m.Authenticated(seenBy(&got)), id)
+	if w.Code != http.StatusOK || got == nil {
+		t.Fatalf("got status %v, want 200", w.Code)
+	}
+
+	if s := got.Context().Value("session").(*Session); s.AccessToken != "fresh" {
+		t.Errorf("handler got access token %q, want the refreshed one", s.AccessToken)
+	}
+
+	s := getTestSession(t, m, id)
+	if s == nil || s.AccessToken != "fresh" || s.RefreshToken != "refresh-rotated" {
+		t.Fatalf("stored session = %+v, want the refreshed tokens", s)
+	}
+
+	if n := store.updateCount(); n != 1 {
+		t.Errorf("got %v session updates, want 1", n)
+	}
+
+	// the next request uses the new token straight away
+	if w := serveSession(m.Authenticated(seenBy(&got)), id); w.Code != http.StatusOK {
+		t.Fatalf("got status %v after refreshing, want 200", w.Code)
+	}
+
+	if _, refreshes := fd.calls(); refreshes != 1 {
+		t.Errorf("got %v refreshes, want 1", refreshes)
+	}
+}
+
+func TestAuthenticatedRefreshesOnceConcurrently(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	fd.refresh["refresh"] = "fresh"
+	fd.refreshGate = make(chan struct{})
+	id := createTestSession(t, m, "stale", "refresh")
+
+	h := m.Authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			codes <- serveSession(h, id).Code
+		}()
+	}
+
+	// hold the exchange until both requests have seen the stale token, so the
+	// second one has to wait for the first one's refresh
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if users, _ := fd.calls(); users >= 2 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("requests never reached discord")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(fd.refreshGate)
+
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("got status %v, want 200", code)
+		}
+	}
+
+	if _, refreshes := fd.calls(); refreshes != 1 {
+		t.Errorf("got %v refreshes, want 1", refreshes)
+	}
+
+	if s := getTestSession(t, m, id); s == nil || s.AccessToken != "fresh" {
+		t.Errorf("stored session = %+v, want the refreshed tokens", s)
+	}
+}
+
+// A refresh by another instance is picked up by polling the store instead of
+// refreshing again.
+func TestRefreshSessionWaitsForLockHolder(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	ctx := context.Background()
+	id := createTestSession(t, m, "stale", "refresh")
+	stale := getTestSession(t, m, id)
+
+	unlock, err := m.Store.Lock(ctx, sessionRefreshLockKey(id), SESSION_REFRESH_LOCK_TTL)
+	if err != nil || unlock == nil {
+		t.Fatalf("failed to take the refresh lock: %v", err)
+	}
+	defer unlock()
+
+	type result struct {
+		s   *Session
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		s, err := m.RefreshSession(ctx, id, stale)
+		done <- result{s, err}
+	}()
+
+	time.Sleep(2 * SESSION_REFRESH_POLL_INTERVAL)
+	_, err = m.Store.Update(ctx, id, func(s *Session) {
+		s.AccessToken = "refreshed-elsewhere"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-done
+	if res.err != nil || res.s == nil || res.s.AccessToken != "refreshed-elsewhere" {
+		t.Fatalf("RefreshSession = %+v, %v, want the other refresh", res.s, res.err)
+	}
+
+	if _, refreshes := fd.calls(); refreshes != 0 {
+		t.Errorf("got %v refreshes, want none", refreshes)
+	}
+}
+
+func TestAuthenticatedRefreshFailureEndsSession(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	// discord doesn't know the refresh token, it answers invalid_grant
+	id := createTestSession(t, m, "stale", "revoked")
+
+	_, err := m.RefreshSession(context.Background(), id, getTestSession(t, m, id))
+	if !errors.Is(err, tyderrors.OAuthRefreshFailed) {
+		t.Fatalf("RefreshSession error = %v, want OAuthRefreshFailed", err)
+	}
+
+	var got *http.Request
+	w := serveSession(m.Authenticated(seenBy(&got)), id)
+	if w.Code != http.StatusUnauthorized || got != nil {
+		t.Fatalf("got status %v, want 401", w.Code)
+	}
+
+	if s := getTestSession(t, m, id); s != nil {
+		t.Errorf("session %+v survived the failed refresh", s)
+	}
+
+	if _, refreshes := fd.calls(); refreshes != 2 {
+		t.Errorf("got %v refresh attempts, want 2", refreshes)
+	}
+}
+
+// approxEqual reports whether a and b are within a second of each other, for
+// times computed from time.Now at slightly different moments.
+func approxEqual(a time.Time, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Second && d < time.Second
+}
+
+func TestShouldRenew(t *testing.T) {
+	now := time.Now()
+	threshold := now.Add(SESSION_TTL - SESSION_RENEW_INTERVAL)
+
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		createdAt   time.Time
+		expiresAt   time.Time
+		want        bool
+	}{
+		{"just created", 0, now, now.Add(SESSION_TTL), false},
+		{"before the threshold", 0, now.Add(-time.Hour), threshold.Add(time.Minute), false},
+		{"after the threshold", 0, now.Add(-2 * SESSION_RENEW_INTERVAL), threshold.Add(-time.Minute), true},
+		{"about to expire", 0, now.Add(-SESSION_TTL), now.Add(time.Minute), true},
+		{"from before expiry was tracked", 0, time.Time{}, time.Time{}, true},
+		{"below the max lifetime", 30 * 24 * time.Hour, now.Add(-3 * 24 * time.Hour), threshold.Add(-time.Minute), true},
+		// already expiring at creation plus the max lifetime
+		{"at the max lifetime", 10 * 24 * time.Hour, now.Add(-8 * 24 * time.Hour), now.Add(2 * 24 * time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := AuthManager{SessionMaxLifetime: tt.maxLifetime}
+
+			got := m.ShouldRenew(&Session{CreatedAt: tt.createdAt, ExpiresAt: tt.expiresAt})
+			if got != tt.want {
+				t.Errorf("ShouldRenew = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		createdAt   time.Time
+		// relative to the renewal
+		want time.Duration
+	}{
+		{"no max lifetime", 0, now.Add(-3 * 24 * time.Hour), SESSION_TTL},
+		{"below the max lifetime", 30 * 24 * time.Hour, now.Add(-3 * 24 * time.Hour), SESSION_TTL},
+		{"capped", 10 * 24 * time.Hour, now.Add(-8 * 24 * time.Hour), 2 * 24 * time.Hour},
+		{"from before expiry was tracked", 10 * 24 * time.Hour, time.Time{}, SESSION_TTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			m.SessionMaxLifetime = tt.maxLifetime
+
+			ctx := context.Background()
+			id := createTestSession(t, m, "access", "refresh")
+			_, err := m.Store.Update(ctx, id, func(s *Session) {
+				s.CreatedAt = tt.createdAt
+				s.ExpiresAt = now.Add(time.Hour)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expiresAt, err := m.RenewSession(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := time.Now().Add(tt.want)
+			if !approxEqual(expiresAt, want) {
+				t.Errorf("RenewSession = %v, want %v", expiresAt, want)
+			}
+
+			s := getTestSession(t, m, id)
+			if !s.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("stored expiry = %v, want %v", s.ExpiresAt, expiresAt)
+			}
+
+			if tt.createdAt.IsZero() {
+				if !approxEqual(s.CreatedAt, time.Now()) {
+					t.Errorf("untracked creation time set to %v, want now", s.CreatedAt)
+				}
+			} else if !s.CreatedAt.Equal(tt.createdAt) {
+				t.Errorf("creation time moved to %v", s.CreatedAt)
+			}
+		})
+	}
+
+	t.Run("deleted session", func(t *testing.T) {
+		m, _ := newTestManager(t)
+
+		expiresAt, err := m.RenewSession(context.Background(), "missing")
+		if err != nil || !expiresAt.IsZero() {
+			t.Errorf("RenewSession = %v, %v, want a zero time", expiresAt, err)
+		}
+	})
+}
+
+func TestAuthenticatedRenewsSessionCookie(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		renewed   bool
+	}{
+		{"before the threshold", SESSION_TTL - time.Hour, false},
+		{"after the threshold", SESSION_TTL - SESSION_RENEW_INTERVAL - time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			fd, srv := newFakeDiscord(t)
+			useDiscord(m, srv)
+
+			fd.valid["access"] = true
+			id := createTestSession(t, m, "access", "refresh")
+
+			before := time.Now().Add(tt.expiresIn)
+			_, err := m.Store.Update(context.Background(), id, func(s *Session) {
+				s.ExpiresAt = before
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got *http.Request
+			w := serveSession(m.Authenticated(seenBy(&got)), id)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %v, want 200", w.Code)
+			}
+
+			var cookie *http.Cookie
+			for _, c := range w.Result().Cookies() {
+				if c.Name == SESSION_ID_COOKIE {
+					cookie = c
+				}
+			}
+
+			s := getTestSession(t, m, id)
+			if !tt.renewed {
+				if cookie != nil {
+					t.Errorf("cookie re-issued: %v", cookie)
+				}
+
+				if !s.ExpiresAt.Equal(before) {
+					t.Errorf("expiry moved to %v", s.ExpiresAt)
+				}
+
+				return
+			}
+
+			if cookie == nil {
+				t.Fatal("cookie wasn't re-issued")
+			}
+
+			if cookie.Value != id || !cookie.HttpOnly || !cookie.Secure {
+				t.Errorf("got cookie %v", cookie)
+			}
+
+			// cookies carry whole seconds
+			if !approxEqual(cookie.Expires, s.ExpiresAt) || !approxEqual(s.ExpiresAt, time.Now().Add(SESSION_TTL)) {
+				t.Errorf("cookie expires %v and session %v, want %v", cookie.Expires, s.ExpiresAt, time.Now().Add(SESSION_TTL))
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const LOGIN_STATE_COOKIE = "oauth_state"
+const LOGIN_STATE_TTL = time.Minute * 10
+
+func loginStateKey(state string) string {
+	return "login_state:" + state
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateLoginURL starts an oauth login by generating a random state and PKCE
+// code verifier, storing the verifier under the state and returning the
+// discord authorize URL along with the state.
+func (m AuthManager) CreateLoginURL(ctx context.Context) (string, string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = m.Store.SetValue(ctx, loginStateKey(state), []byte(verifier), LOGIN_STATE_TTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	url := m.OAuthConfig.AuthCodeURL(
+		state,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	return url, state, nil
+}
+
+// ConsumeLoginState returns the PKCE code verifier stored for the state and
+// deletes it, so every state can only be used once. An empty verifier means the
+// state is unknown or expired.
+func (m AuthManager) ConsumeLoginState(ctx context.Context, state string) (string, error) {
+	verifier, err := m.Store.TakeValue(ctx, loginStateKey(state))
+	if err != nil {
+		return "", err
+	}
+
+	return string(verifier), nil
+}
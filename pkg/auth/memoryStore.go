@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// how often expired entries are swept out of a MemorySessionStore
+const MEMORY_STORE_SWEEP_INTERVAL = time.Minute
+
+// MemorySessionStore keeps sessions in process memory. It is meant for local
+// development and tests, everything is lost on restart and it can't be shared
+// between instances.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*memorySession
+	values    map[string]memoryValue
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	session Session
+	info    SessionInfo
+}
+
+type memoryValue struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:  map[string]*memorySession{},
+		values:    map[string]memoryValue{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (ms *MemorySessionStore) Create(ctx context.Context, id string, s Session, info SessionInfo) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep()
+
+	info.SessionID = id
+	info.ID = PublicSessionID(id)
+	info.UserID = s.UserID
+
+	ms.sessions[id] = &memorySession{
+		session: s,
+		info:    info,
+	}
+
+	return nil
+}
+
+func (ms *MemorySessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry := ms.getSession(id)
+	if entry == nil {
+		return nil, nil
+	}
+
+	sess := entry.session
+	return &sess, nil
+}
+
+func (ms *MemorySessionStore) Update(ctx context.Context, id string, fn func(s *Session)) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry := ms.getSession(id)
+	if entry == nil {
+		return nil, nil
+	}
+
+	sess := entry.session
+	fn(&sess)
+	entry.session = sess
+
+	return &sess, nil
+}
+
+func (ms *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.sessions, id)
+	return nil
+}
+
+func (ms *MemorySessionStore) List(ctx context.Context, userID string) ([]SessionInfo, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sessions := []SessionInfo{}
+	for id := range ms.sessions {
+		entry := ms.getSession(id)
+		if entry != nil && entry.session.UserID == userID {
+			sessions = append(sessions, entry.info)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (ms *MemorySessionStore) Touch(ctx context.Context, id string, ip string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry := ms.getSession(id)
+	if entry == nil {
+		return nil
+	}
+
+	entry.info.LastSeen = time.Now()
+	entry.info.IP = ip
+	return nil
+}
+
+func (ms *MemorySessionStore) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.getValue(key) != nil {
+		return nil, nil
+	}
+
+	token := uuid.New().String()
+	ms.values[key] = memoryValue{
+		value:     []byte(token),
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	return func() {
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+
+		if v, ok := ms.values[key]; ok && string(v.value) == token {
+			delete(ms.values, key)
+		}
+	}, nil
+}
+
+func (ms *MemorySessionStore) SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep()
+
+	ms.values[key] = memoryValue{
+		value:     append([]byte{}, value...),
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	return nil
+}
+
+func (ms *MemorySessionStore) GetValue(ctx context.Context, key string) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.getValue(key), nil
+}
+
+func (ms *MemorySessionStore) TakeValue(ctx context.Context, key string) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	v := ms.getValue(key)
+	delete(ms.values, key)
+
+	return v, nil
+}
+
+func (ms *MemorySessionStore) DeleteValue(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.values, key)
+	return nil
+}
+
+// getSession returns the live session stored under id, dropping it if it has
+// expired. ms.mu must be held.
+func (ms *MemorySessionStore) getSession(id string) *memorySession {
+	entry, ok := ms.sessions[id]
+	if !ok {
+		return nil
+	}
+
+	if !entry.session.ExpiresAt.IsZero() && time.Now().After(entry.session.ExpiresAt) {
+		delete(ms.sessions, id)
+		return nil
+	}
+
+	return entry
+}
+
+// getValue returns the live value stored under key, dropping it if it has
+// expired. ms.mu must be held.
+func (ms *MemorySessionStore) getValue(key string) []byte {
+	v, ok := ms.values[key]
+	if !ok {
+		return nil
+	}
+
+	if time.Now().After(v.expiresAt) {
+		delete(ms.values, key)
+		return nil
+	}
+
+	return v.value
+}
+
+// sweep drops everything that expired, at most once per
+// MEMORY_STORE_SWEEP_INTERVAL. ms.mu must be held.
+func (ms *MemorySessionStore) sweep() {
+	if time.Since(ms.lastSweep) < MEMORY_STORE_SWEEP_INTERVAL {
+		return
+	}
+
+	ms.lastSweep = time.Now()
+
+	for id := range ms.sessions {
+		ms.getSession(id)
+	}
+
+	for key := range ms.values {
+		ms.getValue(key)
+	}
+}
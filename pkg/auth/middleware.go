@@ -3,16 +3,42 @@ package auth
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/thankyoudiscord/api/pkg/database"
 	tyderrors "github.com/thankyoudiscord/api/pkg/errors"
+	"github.com/thankyoudiscord/api/pkg/logging"
 	"github.com/thankyoudiscord/api/pkg/models"
 )
 
-func Authenticated(next http.Handler) http.Handler {
+func SetSessionCookie(w http.ResponseWriter, sessionID string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name: SESSION_ID_COOKIE,
+		// TODO: is this bad?
+		SameSite: http.SameSiteNoneMode,
+		Secure:   true,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+	})
+}
+
+// Authenticated accepts either a session cookie or an API token in the
+// Authorization header, and puts the session and user into the request context.
+// API token requests get a session without discord tokens.
+func (m AuthManager) Authenticated(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("session_id")
+		ctx := r.Context()
+
+		if token, ok := bearerToken(r); ok {
+			m.authenticateAPIToken(w, r, next, token)
+			return
+		}
+
+		c, err := r.Cookie(SESSION_ID_COOKIE)
 		if err != nil {
 			if err == http.ErrNoCookie {
 				w.WriteHeader(http.StatusUnauthorized)
@@ -25,9 +51,9 @@ func Authenticated(next http.Handler) http.Handler {
 
 		sessionId := c.Value
 
-		session, err := mgrSingleton.GetSession(sessionId)
+		session, err := m.GetSession(ctx, sessionId)
 		if err != nil {
-			fmt.Println("failed to get session:", err)
+			logging.Ctx(ctx).Error().Err(err).Msg("failed to get session")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -37,22 +63,53 @@ func Authenticated(next http.Handler) http.Handler {
 			return
 		}
 
-		// TODO: is there a better way to check if the application was revoked?
-		user, err := models.GetUser(session.AccessToken)
+		user, err := m.GetCachedUser(ctx, sessionId)
 		if err != nil {
-			// The oauth token was revoked, so force the user to logout and delete the session
-			if errors.Is(err, tyderrors.DiscordAPIUnauthorized) {
-				mgrSingleton.DeleteSession(sessionId)
-				w.WriteHeader(http.StatusUnauthorized)
+			logging.Ctx(ctx).Warn().Err(err).Msg("failed to get cached user")
+		}
+
+		if user == nil {
+			session, user = m.validateSession(ctx, w, sessionId, session)
+			if user == nil {
 				return
 			}
 
-			fmt.Printf("failed to get user from discord: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			// checked when revalidating so bans take effect within UserCacheTTL
+			blocked, err := m.IsLoginBlocked(ctx, user.ID)
+			if err != nil {
+				logging.Ctx(ctx).Error().Err(err).Msg("failed to check bans")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if blocked {
+				m.EndSession(ctx, sessionId)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write(models.CreateError("You have been banned"))
+				return
+			}
+
+			if err := m.SetCachedUser(ctx, sessionId, user); err != nil {
+				logging.Ctx(ctx).Warn().Err(err).Msg("failed to cache user")
+			}
+
+			// only touched when revalidating so we don't write on every request
+			if err := m.TouchSession(ctx, sessionId, RequestIP(r)); err != nil {
+				logging.Ctx(ctx).Warn().Err(err).Msg("failed to update session info")
+			}
 		}
 
-		ctx := r.Context()
+		if m.ShouldRenew(session) {
+			expiresAt, err := m.RenewSession(ctx, sessionId)
+			if err != nil {
+				logging.Ctx(ctx).Warn().Err(err).Msg("failed to renew session")
+			} else if !expiresAt.IsZero() {
+				SetSessionCookie(w, sessionId, expiresAt)
+			}
+		}
+
+		logging.SetUserID(ctx, user.ID)
+
 		ctx = context.WithValue(ctx, "session_id", sessionId)
 		ctx = context.WithValue(ctx, "session", session)
 		ctx = context.WithValue(ctx, "user", user)
@@ -60,3 +117,146 @@ func Authenticated(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(h[7:]), true
+}
+
+func (m AuthManager) authenticateAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	ctx := r.Context()
+
+	apiToken, err := m.lookupAPIToken(ctx, token)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to look up api token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if apiToken == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	blocked, err := m.IsLoginBlocked(ctx, apiToken.UserID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to check bans")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if blocked {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(models.CreateError("You have been banned"))
+		return
+	}
+
+	dbUser, err := m.Users.GetUser(ctx, apiToken.UserID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to get user for api token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if dbUser == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	user := &models.DiscordUser{
+		ID:            dbUser.UserID,
+		Username:      dbUser.Username,
+		Discriminator: dbUser.Discriminator,
+		Avatar:        dbUser.AvatarHash,
+	}
+
+	logging.SetUserID(ctx, user.ID)
+
+	ctx = context.WithValue(ctx, "session_id", "")
+	ctx = context.WithValue(ctx, "session", &Session{UserID: apiToken.UserID})
+	ctx = context.WithValue(ctx, "user", user)
+	ctx = context.WithValue(ctx, "api_token", apiToken)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireScope rejects API token requests whose token wasn't granted scope.
+// Cookie sessions always pass. Must be used after Authenticated.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiToken, ok := r.Context().Value("api_token").(*database.APIToken)
+			if ok && !apiToken.HasScope(scope) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write(models.CreateError("API token is missing the " + scope + " scope"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rejects API token requests, for routes that only a logged in
+// browser may use. Must be used after Authenticated.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("api_token").(*database.APIToken); ok {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(models.CreateError("This endpoint can't be used with an API token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateSession checks the session's access token against discord,
+// refreshing it if needed, and returns the (possibly refreshed) session along
+// with the user it belongs to. On failure the response has already been
+// written and a nil user is returned.
+func (m AuthManager) validateSession(ctx context.Context, w http.ResponseWriter, sessionId string, session *Session) (*Session, *models.DiscordUser) {
+	// TODO: is there a better way to check if the application was revoked?
+	user, err := m.Discord.GetUser(ctx, session.AccessToken)
+	if errors.Is(err, tyderrors.DiscordAPIUnauthorized) {
+		// The access token expired or was revoked, try to get a new one
+		session, err = m.RefreshSession(ctx, sessionId, session)
+		if err != nil {
+			// The refresh token was revoked too, so force the user to logout and delete the session
+			if errors.Is(err, tyderrors.OAuthRefreshFailed) {
+				m.DeleteSession(ctx, sessionId)
+				w.WriteHeader(http.StatusUnauthorized)
+				return nil, nil
+			}
+
+			logging.Ctx(ctx).Error().Err(err).Msg("failed to refresh session")
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, nil
+		}
+
+		if session == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return nil, nil
+		}
+
+		user, err = m.Discord.GetUser(ctx, session.AccessToken)
+	}
+
+	if err != nil {
+		if errors.Is(err, tyderrors.DiscordAPIUnauthorized) {
+			m.DeleteSession(ctx, sessionId)
+			w.WriteHeader(http.StatusUnauthorized)
+			return nil, nil
+		}
+
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to get user from discord")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil
+	}
+
+	return session, user
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+const TEST_USER_ID = "123456789012345678"
+
+var testUser = models.DiscordUser{ID: TEST_USER_ID, Username: "test"}
+
+// newTestManager returns a manager on top of the memory stores. Its discord
+// client points nowhere, tests that need discord point it at a stub.
+func newTestManager(t *testing.T) (*AuthManager, *database.MemoryStore) {
+	t.Helper()
+
+	db := database.NewMemoryStore()
+	m := NewAuthManager(
+		NewMemorySessionStore(),
+		&oauth2.Config{},
+		db,
+		models.NewDiscord("http://127.0.0.1:1", http.DefaultClient),
+		0,
+		0,
+		false,
+	)
+
+	err := db.UpsertUser(context.Background(), &database.User{
+		UserID:        TEST_USER_ID,
+		Username:      "test",
+		Discriminator: "0001",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, db
+}
+
+// seenBy returns a handler that records the request that reached it.
+func seenBy(got **http.Request) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = r
+	})
+}
+
+func userFromContext(r *http.Request) *models.DiscordUser {
+	return r.Context().Value("user").(*models.DiscordUser)
+}
+
+func serve(h http.Handler, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestAuthenticatedAPIToken(t *testing.T) {
+	m, db := newTestManager(t)
+	ctx := context.Background()
+
+	valid, _, err := m.CreateAPIToken(ctx, TEST_USER_ID, "valid", []string{SCOPE_USER_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// past expiries are rejected when creating, so this one goes in directly
+	expired := API_TOKEN_PREFIX + "expired"
+	past := time.Now().Add(-time.Minute)
+	err = db.CreateAPIToken(ctx, &database.APIToken{
+		UserID:    TEST_USER_ID,
+		Name:      "expired",
+		TokenHash: hashAPIToken(expired),
+		Scopes:    SCOPE_USER_READ,
+		ExpiresAt: &past,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, record, err := m.CreateAPIToken(ctx, TEST_USER_ID, "revoked", []string{SCOPE_USER_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.RevokeAPIToken(ctx, TEST_USER_ID, record.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the user never logged in, so there is no profile for them
+	unknownUser, _, err := m.CreateAPIToken(ctx, "876543210987654321", "unknown", []string{SCOPE_USER_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid", "Bearer " + valid, http.StatusOK},
+		{"lowercase scheme", "bearer " + valid, http.StatusOK},
+		{"no credentials", "", http.StatusUnauthorized},
+		{"unknown token", "Bearer " + API_TOKEN_PREFIX + "unknown", http.StatusUnauthorized},
+		{"missing prefix", "Bearer " + valid[len(API_TOKEN_PREFIX):], http.StatusUnauthorized},
+		{"expired", "Bearer " + expired, http.StatusUnauthorized},
+		{"revoked", "Bearer " + revoked, http.StatusUnauthorized},
+		{"unknown user", "Bearer " + unknownUser, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			w := serve(m.Authenticated(seenBy(&got)), tt.authorization)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				if got != nil {
+					t.Error("request reached the handler")
+				}
+
+				return
+			}
+
+			session := got.Context().Value("session").(*Session)
+			user := userFromContext(got)
+			token := got.Context().Value("api_token").(*database.APIToken)
+			if session.UserID != TEST_USER_ID || user.Username != "test" || token.Name != "valid" {
+				t.Errorf("got session %+v, user %+v and token %+v", session, user, token)
+			}
+
+			if session.AccessToken != "" || session.RefreshToken != "" {
+				t.Error("api token session has discord tokens")
+			}
+		})
+	}
+}
+
+func TestAuthenticatedAPITokenBanned(t *testing.T) {
+	m, db := newTestManager(t)
+	ctx := context.Background()
+
+	token, _, err := m.CreateAPIToken(ctx, TEST_USER_ID, "test", []string{SCOPE_USER_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.BanUser(ctx, &database.Ban{UserID: TEST_USER_ID, Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	if w := serve(m.Authenticated(seenBy(&got)), "Bearer "+token); w.Code != http.StatusOK {
+		t.Fatalf("got status %v while bans don't block logins, want 200", w.Code)
+	}
+
+	m.BansBlockLogin = true
+	got = nil
+	if w := serve(m.Authenticated(seenBy(&got)), "Bearer "+token); w.Code != http.StatusForbidden || got != nil {
+		t.Fatalf("got status %v for a banned user, want 403", w.Code)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	token, _, err := m.CreateAPIToken(ctx, TEST_USER_ID, "test", []string{SCOPE_USER_READ, SCOPE_ADMIN}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scope string
+		want  int
+	}{
+		{SCOPE_USER_READ, http.StatusOK},
+		{SCOPE_ADMIN, http.StatusOK},
+		{SCOPE_SIGNATURE_WRITE, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			var got *http.Request
+			h := m.Authenticated(RequireScope(tt.scope)(seenBy(&got)))
+
+			w := serve(h, "Bearer "+token)
+			if w.Code != tt.want || (got != nil) != (tt.want == http.StatusOK) {
+				t.Fatalf("got status %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+
+	// cookie sessions have every scope
+	t.Run("session", func(t *testing.T) {
+		var got *http.Request
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "session", &Session{UserID: TEST_USER_ID}))
+
+		w := httptest.NewRecorder()
+		RequireScope(SCOPE_SIGNATURE_WRITE)(seenBy(&got)).ServeHTTP(w, req)
+		if w.Code != http.StatusOK || got == nil {
+			t.Fatalf("got status %v, want 200", w.Code)
+		}
+	})
+}
+
+func TestCreateAPITokenExpiry(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Second)
+	now := time.Now()
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		want      error
+	}{
+		{"never", nil, nil},
+		{"future", &future, nil},
+		{"now", &now, ErrExpiryInPast},
+		{"past", &past, ErrExpiryInPast},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, record, err := m.CreateAPIToken(ctx, TEST_USER_ID, tt.name, []string{SCOPE_USER_READ}, tt.expiresAt)
+			if err != tt.want {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+
+			if tt.want != nil && (token != "" || record != nil) {
+				t.Error("a token was issued anyway")
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/thankyoudiscord/api/pkg/logging"
+)
+
+// how many times Update retries when the session is changed concurrently
+const REDIS_UPDATE_RETRIES = 5
+
+type RedisSessionStore struct {
+	RedisClient *redis.Client
+
+	// sessions hold working discord tokens, so they are never stored in the
+	// clear
+	Cipher *SessionCipher
+}
+
+func NewRedisSessionStore(r *redis.Client, c *SessionCipher) RedisSessionStore {
+	return RedisSessionStore{
+		RedisClient: r,
+		Cipher:      c,
+	}
+}
+
+// deletes the lock only if we are still the ones holding it
+var releaseLockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+
+	return 0
+`)
+
+func sessionRedisKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func sessionInfoRedisKey(sessionID string) string {
+	return "session_info:" + sessionID
+}
+
+func userSessionsRedisKey(userID string) string {
+	return "user_sessions:" + userID
+}
+
+func (rs RedisSessionStore) encodeSession(id string, s Session) ([]byte, error) {
+	b, err := marshalSession(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.Cipher.Seal(id, b)
+}
+
+// decodeSession also reports whether the session is stored in an outdated
+// format or with an old key and should be rewritten.
+func (rs RedisSessionStore) decodeSession(id string, b []byte) (*Session, bool, error) {
+	b, staleKey, err := rs.Cipher.Open(id, b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sess, staleFormat, err := unmarshalSession(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return sess, staleKey || staleFormat, nil
+}
+
+func (rs RedisSessionStore) Create(ctx context.Context, id string, s Session, info SessionInfo) error {
+	sess, err := rs.encodeSession(id, s)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to encode session data")
+		return err
+	}
+
+	key := sessionRedisKey(id)
+	infoKey := sessionInfoRedisKey(id)
+	userKey := userSessionsRedisKey(s.UserID)
+	ttl := time.Until(s.ExpiresAt)
+
+	// the index lives as long as the user's longest session
+	userTTL, err := rs.RedisClient.PTTL(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = rs.RedisClient.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SetEX(ctx, key, sess, ttl)
+
+		p.HSet(ctx, infoKey, map[string]interface{}{
+			"user_id":    s.UserID,
+			"created_at": info.CreatedAt.Unix(),
+			"last_seen":  info.LastSeen.Unix(),
+			"ip":         info.IP,
+			"user_agent": info.UserAgent,
+		})
+		p.Expire(ctx, infoKey, ttl)
+
+		p.SAdd(ctx, userKey, id)
+		if userTTL < ttl {
+			p.Expire(ctx, userKey, ttl)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (rs RedisSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	key := sessionRedisKey(id)
+	res := rs.RedisClient.Get(ctx, key)
+	if res.Err() != nil {
+		if res.Err() == redis.Nil {
+			return nil, nil
+		}
+
+		return nil, res.Err()
+	}
+
+	b, err := res.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	sess, stale, err := rs.decodeSession(id, b)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to decode session")
+		return nil, err
+	}
+
+	// upgrade it in place so old formats and keys can eventually be dropped
+	if stale {
+		if _, err := rs.Update(ctx, id, func(s *Session) {}); err != nil {
+			logging.Ctx(ctx).Warn().Err(err).Msg("failed to upgrade session")
+		}
+	}
+
+	return sess, nil
+}
+
+func (rs RedisSessionStore) Update(ctx context.Context, id string, fn func(s *Session)) (*Session, error) {
+	key := sessionRedisKey(id)
+
+	var updated *Session
+	txf := func(tx *redis.Tx) error {
+		updated = nil
+
+		b, err := tx.Get(ctx, key).Bytes()
+		if err != nil {
+			if err == redis.Nil {
+				return nil
+			}
+
+			return err
+		}
+
+		sess, _, err := rs.decodeSession(id, b)
+		if err != nil {
+			logging.Ctx(ctx).Error().Err(err).Msg("failed to decode session")
+			return err
+		}
+
+		expiresAt := sess.ExpiresAt
+		fn(sess)
+
+		enc, err := rs.encodeSession(id, *sess)
+		if err != nil {
+			logging.Ctx(ctx).Error().Err(err).Msg("failed to encode session data")
+			return err
+		}
+
+		ttl, err := tx.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		extended := !sess.ExpiresAt.Equal(expiresAt)
+		if extended {
+			ttl = time.Until(sess.ExpiresAt)
+		}
+
+		userKey := userSessionsRedisKey(sess.UserID)
+		userTTL, err := tx.PTTL(ctx, userKey).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, key, enc, ttl)
+
+			if extended {
+				p.Expire(ctx, sessionInfoRedisKey(id), ttl)
+
+				if userTTL < ttl {
+					p.Expire(ctx, userKey, ttl)
+				}
+			}
+
+			return nil
+		})
+
+		if err == nil {
+			updated = sess
+		}
+
+		return err
+	}
+
+	for i := 0; i < REDIS_UPDATE_RETRIES; i++ {
+		err := rs.RedisClient.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return updated, err
+		}
+	}
+
+	return nil, fmt.Errorf("too many concurrent updates to session id=%v", id)
+}
+
+func (rs RedisSessionStore) Delete(ctx context.Context, id string) error {
+	userID, err := rs.RedisClient.HGet(ctx, sessionInfoRedisKey(id), "user_id").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = rs.RedisClient.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, sessionRedisKey(id), sessionInfoRedisKey(id))
+
+		if userID != "" {
+			p.SRem(ctx, userSessionsRedisKey(userID), id)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// List also prunes sessions that expired from the user's index.
+func (rs RedisSessionStore) List(ctx context.Context, userID string) ([]SessionInfo, error) {
+	userKey := userSessionsRedisKey(userID)
+
+	ids, err := rs.RedisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	exists := make([]*redis.IntCmd, len(ids))
+	_, err = rs.RedisClient.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HGetAll(ctx, sessionInfoRedisKey(id))
+			exists[i] = p.Exists(ctx, sessionRedisKey(id))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []SessionInfo{}
+	var stale []interface{}
+	for i, id := range ids {
+		fields := cmds[i].Val()
+		if len(fields) == 0 || exists[i].Val() == 0 {
+			stale = append(stale, id)
+			continue
+		}
+
+		sessions = append(sessions, parseSessionInfo(id, fields))
+	}
+
+	if len(stale) != 0 {
+		rs.RedisClient.SRem(ctx, userKey, stale...)
+	}
+
+	return sessions, nil
+}
+
+func (rs RedisSessionStore) Touch(ctx context.Context, id string, ip string) error {
+	key := sessionInfoRedisKey(id)
+
+	// don't recreate the info of a session that was deleted in the meantime
+	exists, err := rs.RedisClient.Exists(ctx, key).Result()
+	if err != nil || exists == 0 {
+		return err
+	}
+
+	return rs.RedisClient.HSet(ctx, key, map[string]interface{}{
+		"last_seen": time.Now().Unix(),
+		"ip":        ip,
+	}).Err()
+}
+
+func (rs RedisSessionStore) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	token := uuid.New().String()
+
+	acquired, err := rs.RedisClient.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !acquired {
+		return nil, err
+	}
+
+	// released even if the request that took the lock was canceled
+	return func() {
+		releaseLockScript.Run(context.Background(), rs.RedisClient, []string{key}, token)
+	}, nil
+}
+
+func (rs RedisSessionStore) SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return rs.RedisClient.SetEX(ctx, key, value, ttl).Err()
+}
+
+func (rs RedisSessionStore) GetValue(ctx context.Context, key string) ([]byte, error) {
+	b, err := rs.RedisClient.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	return b, err
+}
+
+func (rs RedisSessionStore) TakeValue(ctx context.Context, key string) ([]byte, error) {
+	var get *redis.StringCmd
+	_, err := rs.RedisClient.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.Get(ctx, key)
+		p.Del(ctx, key)
+		return nil
+	})
+
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	if get.Err() == redis.Nil {
+		return nil, nil
+	}
+
+	return get.Bytes()
+}
+
+func (rs RedisSessionStore) DeleteValue(ctx context.Context, key string) error {
+	return rs.RedisClient.Del(ctx, key).Err()
+}
+
+func parseSessionInfo(id string, fields map[string]string) SessionInfo {
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+
+	return SessionInfo{
+		ID:        PublicSessionID(id),
+		SessionID: id,
+		UserID:    fields["user_id"],
+		CreatedAt: time.Unix(createdAt, 0),
+		LastSeen:  time.Unix(lastSeen, 0),
+		IP:        fields["ip"],
+		UserAgent: fields["user_agent"],
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedisStore(t *testing.T) (RedisSessionStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisSessionStore(client, testCipher(t, testKey(1))), mr
+}
+
+// approxTTL reports whether a TTL is within two seconds of want, miniredis
+// keeps whole seconds.
+func approxTTL(got time.Duration, want time.Duration) bool {
+	d := got - want
+	return d > -2*time.Second && d < 2*time.Second
+}
+
+func TestRedisSessionStoreUpdateExtendsTTLs(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	create := func(id string, ttl time.Duration) {
+		t.Helper()
+
+		s := Session{UserID: TEST_USER_ID, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(ttl)}
+		if err := store.Create(ctx, id, s, SessionInfo{CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertTTLs := func(id string, session time.Duration, user time.Duration) {
+		t.Helper()
+
+		if got := mr.TTL(sessionRedisKey(id)); !approxTTL(got, session) {
+			t.Errorf("session TTL = %v, want %v", got, session)
+		}
+
+		if got := mr.TTL(sessionInfoRedisKey(id)); !approxTTL(got, session) {
+			t.Errorf("session info TTL = %v, want %v", got, session)
+		}
+
+		if got := mr.TTL(userSessionsRedisKey(TEST_USER_ID)); !approxTTL(got, user) {
+			t.Errorf("user index TTL = %v, want %v", got, user)
+		}
+	}
+
+	create("a", time.Hour)
+	assertTTLs("a", time.Hour, time.Hour)
+
+	// changes that leave the expiry alone keep the TTLs
+	if _, err := store.Update(ctx, "a", func(s *Session) { s.AccessToken = "new" }); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTTLs("a", time.Hour, time.Hour)
+
+	if _, err := store.Update(ctx, "a", func(s *Session) { s.ExpiresAt = time.Now().Add(5 * time.Hour) }); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTTLs("a", 5*time.Hour, 5*time.Hour)
+
+	// the index outlives the user's longest session, creating or extending a
+	// shorter one doesn't cut it short
+	create("b", 10*time.Hour)
+	create("c", time.Hour)
+	assertTTLs("c", time.Hour, 10*time.Hour)
+
+	if _, err := store.Update(ctx, "c", func(s *Session) { s.ExpiresAt = time.Now().Add(2 * time.Hour) }); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTTLs("c", 2*time.Hour, 10*time.Hour)
+}
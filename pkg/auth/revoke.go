@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/metrics"
+)
+
+const REVOKE_MAX_ATTEMPTS = 8
+const REVOKE_INITIAL_BACKOFF = time.Second
+const REVOKE_MAX_BACKOFF = time.Minute * 5
+
+// revokeError is returned for revocations that can't succeed by retrying.
+type revokeError struct {
+	status int
+}
+
+func (e revokeError) Error() string {
+	return fmt.Sprintf("discord responded to token revocation with status %v", e.status)
+}
+
+// EndSession deletes the session and revokes its discord tokens.
+func (m AuthManager) EndSession(ctx context.Context, id string) error {
+	sess, err := m.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := m.DeleteSession(ctx, id); err != nil {
+		return err
+	}
+
+	if sess != nil {
+		m.RevokeTokens(ctx, sess)
+	}
+
+	return nil
+}
+
+// RevokeTokens revokes the session's access and refresh token with discord.
+// Revocations that fail are retried in the background with exponential
+// backoff, so this never blocks on more than one attempt per token.
+//
+// Pending retries only live in memory and are lost on restart.
+func (m AuthManager) RevokeTokens(ctx context.Context, s *Session) {
+	tokens := []struct {
+		token string
+		hint  string
+	}{
+		{s.RefreshToken, "refresh_token"},
+		{s.AccessToken, "access_token"},
+	}
+
+	for _, t := range tokens {
+		if t.token == "" {
+			continue
+		}
+
+		err := m.revokeToken(ctx, t.token, t.hint)
+		if err == nil {
+			continue
+		}
+
+		if _, ok := err.(revokeError); ok {
+			logging.Ctx(ctx).Error().Err(err).Str("hint", t.hint).Msg("failed to revoke token")
+			continue
+		}
+
+		logging.Ctx(ctx).Warn().Err(err).Str("hint", t.hint).Msg("failed to revoke token, retrying in the background")
+		go m.retryRevokeToken(logging.Ctx(ctx), t.token, t.hint)
+	}
+}
+
+// retryRevokeToken logs with the logger of the request that ended the session.
+func (m AuthManager) retryRevokeToken(log *zerolog.Logger, token string, hint string) {
+	// outlives the request that ended the session
+	ctx := context.Background()
+	backoff := REVOKE_INITIAL_BACKOFF
+
+	sleep := m.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	for attempt := 2; attempt <= REVOKE_MAX_ATTEMPTS; attempt++ {
+		sleep(backoff)
+
+		err := m.revokeToken(ctx, token, hint)
+		if err == nil {
+			return
+		}
+
+		if _, ok := err.(revokeError); ok {
+			log.Error().Err(err).Str("hint", hint).Msg("failed to revoke token")
+			return
+		}
+
+		log.Warn().
+			Err(err).
+			Str("hint", hint).
+			Int("attempt", attempt).
+			Int("max_attempts", REVOKE_MAX_ATTEMPTS).
+			Msg("failed to revoke token")
+
+		backoff *= 2
+		if backoff > REVOKE_MAX_BACKOFF {
+			backoff = REVOKE_MAX_BACKOFF
+		}
+	}
+
+	log.Error().Str("hint", hint).Msg("giving up on revoking token")
+}
+
+func (m AuthManager) revokeToken(ctx context.Context, token string, hint string) error {
+	pl := url.Values{}
+	pl.Set("token", token)
+	pl.Set("token_type_hint", hint)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		m.OAuthConfig.Endpoint.TokenURL+"/revoke",
+		strings.NewReader(pl.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(
+		url.QueryEscape(m.OAuthConfig.ClientID),
+		url.QueryEscape(m.OAuthConfig.ClientSecret),
+	)
+
+	res, err := m.Discord.HTTPClient.Do(req)
+	metrics.ObserveDiscordRequest(metrics.DISCORD_REVOKE_TOKEN, res, err)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	// rate limits and discord outages are worth retrying, anything else isn't
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		return fmt.Errorf("discord responded to token revocation with status %v", res.StatusCode)
+	}
+
+	return revokeError{status: res.StatusCode}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// recordSleeps makes the manager's revocation retries record their backoff
+// instead of waiting.
+func recordSleeps(m *AuthManager) func() []time.Duration {
+	var mu sync.Mutex
+	var sleeps []time.Duration
+
+	m.sleep = func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sleeps = append(sleeps, d)
+	}
+
+	return func() []time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]time.Duration{}, sleeps...)
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestRetryRevokeToken(t *testing.T) {
+	unavailable := http.StatusServiceUnavailable
+
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		sleeps   []time.Duration
+	}{
+		{
+			name:     "revoked",
+			attempts: 1,
+			sleeps:   []time.Duration{time.Second},
+		},
+		{
+			name:     "recovers",
+			statuses: []int{unavailable, http.StatusTooManyRequests},
+			attempts: 3,
+			sleeps:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:     "rejected",
+			statuses: []int{unavailable, http.StatusBadRequest},
+			attempts: 2,
+			sleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			// the first attempt is made by RevokeTokens
+			name:     "gives up",
+			statuses: []int{unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable},
+			attempts: REVOKE_MAX_ATTEMPTS - 1,
+			sleeps: []time.Duration{
+				time.Second,
+				2 * time.Second,
+				4 * time.Second,
+				8 * time.Second,
+				16 * time.Second,
+				32 * time.Second,
+				64 * time.Second,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			fd, srv := newFakeDiscord(t)
+			useDiscord(m, srv)
+
+			fd.revokeStatuses = tt.statuses
+			sleeps := recordSleeps(m)
+
+			log := zerolog.Nop()
+			m.retryRevokeToken(&log, "token", "access_token")
+
+			if got := fd.revokedTokens(); len(got) != tt.attempts {
+				t.Errorf("got %v attempts, want %v", len(got), tt.attempts)
+			}
+
+			got := sleeps()
+			if len(got) != len(tt.sleeps) {
+				t.Fatalf("slept %v, want %v", got, tt.sleeps)
+			}
+
+			for i := range got {
+				if got[i] != tt.sleeps[i] || got[i] > REVOKE_MAX_BACKOFF {
+					t.Fatalf("slept %v, want %v", got, tt.sleeps)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		// every revocation made, including retries
+		revoked []string
+	}{
+		{"revoked", nil, []string{"refresh", "access"}},
+		{"rejected", []int{http.StatusBadRequest, http.StatusUnauthorized}, []string{"refresh", "access"}},
+		{"retried", []int{http.StatusServiceUnavailable}, []string{"refresh", "access", "refresh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			fd, srv := newFakeDiscord(t)
+			useDiscord(m, srv)
+
+			fd.revokeStatuses = tt.statuses
+			sleeps := recordSleeps(m)
+
+			m.RevokeTokens(context.Background(), &Session{AccessToken: "access", RefreshToken: "refresh"})
+
+			// retries run in the background
+			deadline := time.Now().Add(5 * time.Second)
+			for len(fd.revokedTokens()) < len(tt.revoked) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// and nothing more comes after the ones we expect
+			time.Sleep(50 * time.Millisecond)
+
+			if got := fd.revokedTokens(); !equalStrings(got, tt.revoked) {
+				t.Errorf("revoked %v, want %v", got, tt.revoked)
+			}
+
+			retries := len(tt.revoked) - 2
+			if got := sleeps(); len(got) != retries {
+				t.Errorf("slept %v, want %v retries", got, retries)
+			}
+		})
+	}
+}
+
+func TestEndSessionDeletesBeforeRevoking(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	ctx := context.Background()
+	id := createTestSession(t, m, "access", "refresh")
+
+	var mu sync.Mutex
+	var liveDuringRevoke []string
+	fd.onRevoke = func(token string) {
+		s, err := m.Store.Get(ctx, id)
+		if err != nil || s != nil {
+			mu.Lock()
+			liveDuringRevoke = append(liveDuringRevoke, token)
+			mu.Unlock()
+		}
+	}
+
+	if err := m.EndSession(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fd.revokedTokens(); !equalStrings(got, []string{"refresh", "access"}) {
+		t.Errorf("revoked %v, want both tokens", got)
+	}
+
+	if len(liveDuringRevoke) != 0 {
+		t.Errorf("session still existed while revoking %v", liveDuringRevoke)
+	}
+
+	if s := getTestSession(t, m, id); s != nil {
+		t.Errorf("session %+v survived EndSession", s)
+	}
+
+	// nothing to revoke for a session that is already gone
+	if err := m.EndSession(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fd.revokedTokens(); len(got) != 2 {
+		t.Errorf("revoked %v, want nothing more", got)
+	}
+}
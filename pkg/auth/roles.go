@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+// Roles in increasing order of privilege, every role can do everything the
+// roles before it can.
+const (
+	ROLE_VIEWER    = "viewer"
+	ROLE_MODERATOR = "moderator"
+	ROLE_ADMIN     = "admin"
+)
+
+var roleRanks = map[string]int{
+	ROLE_VIEWER:    1,
+	ROLE_MODERATOR: 2,
+	ROLE_ADMIN:     3,
+}
+
+var ErrUnknownRole = errors.New("unknown role")
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the privileges of required.
+func HasRole(role string, required string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
+
+// GetUserRole returns the user's role, or an empty string if they have none.
+func (m AuthManager) GetUserRole(ctx context.Context, userID string) (string, error) {
+	return m.Roles.GetUserRole(ctx, userID)
+}
+
+// SetUserRole grants a role to a user, replacing any role they had.
+func (m AuthManager) SetUserRole(ctx context.Context, userID string, role string, grantedBy *string) error {
+	if !ValidRole(role) {
+		return ErrUnknownRole
+	}
+
+	return m.Roles.SetUserRole(ctx, &database.UserRole{
+		UserID:    userID,
+		Role:      role,
+		GrantedBy: grantedBy,
+	})
+}
+
+// RemoveUserRole takes away a user's role. It returns false if they had none.
+func (m AuthManager) RemoveUserRole(ctx context.Context, userID string) (bool, error) {
+	return m.Roles.RemoveUserRole(ctx, userID)
+}
+
+func (m AuthManager) ListUserRoles(ctx context.Context) ([]database.UserRole, error) {
+	return m.Roles.ListUserRoles(ctx)
+}
+
+// SeedAdmins makes sure the given users are admins, so there is always someone
+// who can hand out roles.
+func (m AuthManager) SeedAdmins(ctx context.Context, userIDs []string) error {
+	for _, id := range userIDs {
+		if err := m.SetUserRole(ctx, id, ROLE_ADMIN, nil); err != nil {
+			return fmt.Errorf("failed to seed admin %v: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// RequireRole rejects users without at least the given role and puts the
+// user's role into the request context. Must be used after Authenticated.
+func (m AuthManager) RequireRole(required string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := r.Context().Value("session").(*Session)
+
+			role, err := m.GetUserRole(r.Context(), session.UserID)
+			if err != nil {
+				logging.Ctx(r.Context()).Error().Err(err).Msg("failed to get user role")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !HasRole(role, required) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write(models.CreateError("You don't have permission to do that"))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "role", role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sealed sessions start with a zero byte, which a gob stream never does, so
+// sessions written before encryption can still be told apart and read.
+var sealedSessionPrefix = []byte{0x00, 'E'}
+
+const sessionKeyIDLength = 4
+
+var ErrUnknownSessionKey = errors.New("session was sealed with an unknown key")
+
+// SessionCipher encrypts stored sessions with AES-GCM. The first key seals
+// new data, the rest are only used to open data sealed before a key rotation.
+type SessionCipher struct {
+	keys []sessionKey
+}
+
+type sessionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// ParseSessionKeys parses a comma separated list of base64 encoded 32 byte
+// keys, newest first.
+func ParseSessionKeys(s string) ([][]byte, error) {
+	var keys [][]byte
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session key: %w", err)
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("session keys must be 32 bytes, got %v", len(key))
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no session keys given")
+	}
+
+	return keys, nil
+}
+
+func NewSessionCipher(keys [][]byte) (*SessionCipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no session keys given")
+	}
+
+	c := &SessionCipher{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := sha256.Sum256(key)
+		c.keys = append(c.keys, sessionKey{
+			id:   id[:sessionKeyIDLength],
+			aead: aead,
+		})
+	}
+
+	return c, nil
+}
+
+// Seal encrypts a session with the newest key. The session ID is bound to the
+// ciphertext so sealed sessions can't be moved to another ID.
+func (c *SessionCipher) Seal(sessionID string, plaintext []byte) ([]byte, error) {
+	key := c.keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(sealedSessionPrefix)+len(key.id)+len(nonce)+len(plaintext)+key.aead.Overhead())
+	out = append(out, sealedSessionPrefix...)
+	out = append(out, key.id...)
+	out = append(out, nonce...)
+
+	return key.aead.Seal(out, nonce, plaintext, []byte(sessionID)), nil
+}
+
+// Open decrypts a session sealed with any of the keys and reports whether it
+// should be sealed again with the newest key. Sessions stored before
+// encryption was added are returned as is.
+func (c *SessionCipher) Open(sessionID string, b []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(b, sealedSessionPrefix) {
+		return b, true, nil
+	}
+
+	b = b[len(sealedSessionPrefix):]
+	if len(b) < sessionKeyIDLength {
+		return nil, false, errors.New("sealed session is too short")
+	}
+
+	id, b := b[:sessionKeyIDLength], b[sessionKeyIDLength:]
+	for i, key := range c.keys {
+		if !bytes.Equal(key.id, id) {
+			continue
+		}
+
+		if len(b) < key.aead.NonceSize() {
+			return nil, false, errors.New("sealed session is too short")
+		}
+
+		nonce, ciphertext := b[:key.aead.NonceSize()], b[key.aead.NonceSize():]
+		plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(sessionID))
+		return plaintext, i != 0, err
+	}
+
+	return nil, false, ErrUnknownSessionKey
+}
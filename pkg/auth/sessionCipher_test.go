@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func testCipher(t *testing.T, keys ...[]byte) *SessionCipher {
+	t.Helper()
+
+	c, err := NewSessionCipher(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestSessionCipherRoundTrip(t *testing.T) {
+	c := testCipher(t, testKey(1))
+
+	plaintext := []byte(`{"v":1,"session":{"user_id":"123"}}`)
+	sealed, err := c.Seal("session-a", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("sealed session contains the plaintext")
+	}
+
+	opened, reseal, err := c.Open("session-a", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open = %q, want %q", opened, plaintext)
+	}
+
+	if reseal {
+		t.Error("session sealed with the newest key shouldn't need resealing")
+	}
+
+	again, err := c.Seal("session-a", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing twice gave the same ciphertext, nonces aren't random")
+	}
+}
+
+func TestSessionCipherKeyRotation(t *testing.T) {
+	old := testCipher(t, testKey(1))
+	sealed, err := old.Seal("session-a", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old key is rotated out but still listed
+	rotated := testCipher(t, testKey(2), testKey(1))
+	opened, reseal, err := rotated.Open("session-a", sealed)
+	if err != nil {
+		t.Fatalf("failed to open with a rotated out key: %v", err)
+	}
+
+	if string(opened) != "data" {
+		t.Errorf("Open = %q, want %q", opened, "data")
+	}
+
+	if !reseal {
+		t.Error("session sealed with an old key should be resealed")
+	}
+
+	// and dropped entirely
+	dropped := testCipher(t, testKey(2))
+	if _, _, err := dropped.Open("session-a", sealed); !errors.Is(err, ErrUnknownSessionKey) {
+		t.Errorf("Open with the key dropped = %v, want ErrUnknownSessionKey", err)
+	}
+}
+
+func TestSessionCipherRejectsOtherSessionID(t *testing.T) {
+	c := testCipher(t, testKey(1))
+
+	sealed, err := c.Seal("session-a", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := c.Open("session-b", sealed); err == nil {
+		t.Error("opened a session under another session ID")
+	}
+}
+
+func TestSessionCipherDetectsTampering(t *testing.T) {
+	c := testCipher(t, testKey(1))
+
+	sealed, err := c.Seal("session-a", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headerLen := len(sealedSessionPrefix) + sessionKeyIDLength
+
+	tests := []struct {
+		name   string
+		tamper func(b []byte) []byte
+	}{
+		{"flipped nonce bit", func(b []byte) []byte {
+			b[headerLen] ^= 1
+			return b
+		}},
+		{"flipped ciphertext bit", func(b []byte) []byte {
+			b[len(b)-20] ^= 1
+			return b
+		}},
+		{"flipped tag bit", func(b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		}},
+		{"truncated", func(b []byte) []byte {
+			return b[:len(b)-1]
+		}},
+		{"cut after key id", func(b []byte) []byte {
+			return b[:headerLen+2]
+		}},
+		{"cut in key id", func(b []byte) []byte {
+			return b[:len(sealedSessionPrefix)+1]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.tamper(append([]byte(nil), sealed...))
+			if _, _, err := c.Open("session-a", b); err == nil {
+				t.Error("opened a tampered session")
+			}
+		})
+	}
+}
+
+func TestSessionCipherPassesThroughLegacyData(t *testing.T) {
+	c := testCipher(t, testKey(1))
+
+	for _, legacy := range [][]byte{
+		[]byte(`{"v":1,"session":{}}`),
+		// gob streams start with a length byte, never zero
+		{0x3f, 0xff, 0x81, 0x03},
+	} {
+		opened, reseal, err := c.Open("session-a", legacy)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", legacy, err)
+		}
+
+		if !bytes.Equal(opened, legacy) {
+			t.Errorf("Open(%q) = %q, want it unchanged", legacy, opened)
+		}
+
+		if !reseal {
+			t.Errorf("unencrypted session %q should be sealed on the next write", legacy)
+		}
+	}
+}
+
+func TestParseSessionKeys(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	keys, err := ParseSessionKeys(k1 + ", " + k2 + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || !bytes.Equal(keys[0], testKey(1)) || !bytes.Equal(keys[1], testKey(2)) {
+		t.Errorf("ParseSessionKeys = %v, want both keys in order", keys)
+	}
+
+	for _, invalid := range []string{
+		"",
+		" , ",
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("too short")),
+	} {
+		if _, err := ParseSessionKeys(invalid); err == nil {
+			t.Errorf("ParseSessionKeys(%q) succeeded", invalid)
+		}
+	}
+}
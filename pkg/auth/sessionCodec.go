@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+// Stored sessions start with a version byte followed by the encoded session.
+//
+// Sessions used to be bare gob streams. A gob stream starts with the length of
+// its first message, which for a Session is far larger than any version we
+// use, so those can still be recognised and decoded.
+const (
+	SESSION_FORMAT_JSON byte = 1
+
+	sessionFormatCurrent = SESSION_FORMAT_JSON
+	sessionFormatMax     = SESSION_FORMAT_JSON
+)
+
+var ErrUnknownSessionFormat = errors.New("unknown session format")
+
+func marshalSession(s Session) ([]byte, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{sessionFormatCurrent}, b...), nil
+}
+
+// unmarshalSession decodes a session in any format we ever wrote and reports
+// whether it is outdated and should be rewritten.
+func unmarshalSession(b []byte) (*Session, bool, error) {
+	if len(b) == 0 {
+		return nil, false, ErrUnknownSessionFormat
+	}
+
+	var sess Session
+	switch {
+	case b[0] == SESSION_FORMAT_JSON:
+		if err := json.Unmarshal(b[1:], &sess); err != nil {
+			return nil, false, err
+		}
+
+	case b[0] > sessionFormatMax:
+		dec := gob.NewDecoder(bytes.NewReader(b))
+		if err := dec.Decode(&sess); err != nil {
+			return nil, false, err
+		}
+
+	default:
+		return nil, false, ErrUnknownSessionFormat
+	}
+
+	return &sess, b[0] != sessionFormatCurrent, nil
+}
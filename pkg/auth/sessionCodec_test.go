@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// testdata/legacy_session.gob is a session as written before the JSON format,
+// a bare gob stream of the three field Session struct.
+func legacySession(t *testing.T) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/legacy_session.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestUnmarshalLegacyGobSession(t *testing.T) {
+	sess, stale, err := unmarshalSession(legacySession(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Session{
+		RefreshToken: "legacy-refresh",
+		AccessToken:  "legacy-access",
+		UserID:       "123456789012345678",
+	}
+
+	if *sess != want {
+		t.Errorf("unmarshalSession = %+v, want %+v", *sess, want)
+	}
+
+	if !stale {
+		t.Error("gob session should be reported as stale")
+	}
+}
+
+func TestSessionJSONRoundTrip(t *testing.T) {
+	s := Session{
+		RefreshToken: "refresh",
+		AccessToken:  "access",
+		UserID:       "123",
+		CreatedAt:    time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt:    time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	b, err := marshalSession(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b[0] != SESSION_FORMAT_JSON {
+		t.Fatalf("format byte = %v, want %v", b[0], SESSION_FORMAT_JSON)
+	}
+
+	sess, stale, err := unmarshalSession(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *sess != s {
+		t.Errorf("unmarshalSession = %+v, want %+v", *sess, s)
+	}
+
+	if stale {
+		t.Error("current format shouldn't be reported as stale")
+	}
+}
+
+func TestUnmarshalUnknownSessionFormat(t *testing.T) {
+	for _, b := range [][]byte{nil, {0x00, '{', '}'}} {
+		if _, _, err := unmarshalSession(b); !errors.Is(err, ErrUnknownSessionFormat) {
+			t.Errorf("unmarshalSession(%q) = %v, want ErrUnknownSessionFormat", b, err)
+		}
+	}
+}
+
+// A gob session left in redis from before encryption and the JSON format is
+// read through the format check and rewritten sealed and as JSON.
+func TestRedisSessionStoreUpgradesLegacySession(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store := NewRedisSessionStore(client, testCipher(t, testKey(1)))
+	ctx := context.Background()
+	id := "legacy-session-id"
+
+	legacy := legacySession(t)
+	if err := client.Set(ctx, sessionRedisKey(id), legacy, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sess == nil || sess.AccessToken != "legacy-access" || sess.UserID != "123456789012345678" {
+		t.Fatalf("Get = %+v, want the legacy session", sess)
+	}
+
+	stored, err := client.Get(ctx, sessionRedisKey(id)).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(stored, sealedSessionPrefix) {
+		t.Fatal("upgraded session wasn't sealed")
+	}
+
+	if mr.TTL(sessionRedisKey(id)) <= 0 {
+		t.Error("upgraded session lost its TTL")
+	}
+
+	plain, _, err := store.Cipher.Open(id, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plain[0] != SESSION_FORMAT_JSON {
+		t.Errorf("upgraded session format = %v, want JSON", plain[0])
+	}
+
+	// and reads back the same
+	again, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *again != *sess {
+		t.Errorf("Get after upgrade = %+v, want %+v", *again, *sess)
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SessionInfo is the metadata kept next to a session so users can see where
+// they are logged in.
+type SessionInfo struct {
+	// ID is derived from the session ID, the session ID itself is a credential
+	// and is never handed out
+	ID        string    `json:"id"`
+	SessionID string    `json:"-"`
+	UserID    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// PublicSessionID returns the identifier a session is listed and revoked by.
+func PublicSessionID(sessionID string) string {
+	h := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(h[:16])
+}
+
+// RequestIP returns the client IP of a request without the port.
+func RequestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// TouchSession records that the session was just used from the given IP.
+func (m AuthManager) TouchSession(ctx context.Context, id string, ip string) error {
+	return m.Store.Touch(ctx, id, ip)
+}
+
+// ListSessions returns all live sessions of a user, most recently used first.
+func (m AuthManager) ListSessions(ctx context.Context, userID string) ([]SessionInfo, error) {
+	sessions, err := m.Store.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+
+	return sessions, nil
+}
+
+// DeleteSessionByPublicID ends one of the user's sessions and revokes its
+// discord tokens. It returns false if the user has no session with that ID.
+func (m AuthManager) DeleteSessionByPublicID(ctx context.Context, userID string, publicID string) (bool, error) {
+	sessions, err := m.Store.List(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range sessions {
+		if s.ID == publicID {
+			return true, m.EndSession(ctx, s.SessionID)
+		}
+	}
+
+	return false, nil
+}
+
+// DeleteUserSessions logs a user out everywhere, revoking the discord tokens of
+// every session.
+func (m AuthManager) DeleteUserSessions(ctx context.Context, userID string) error {
+	sessions, err := m.Store.List(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if err := m.EndSession(ctx, s.SessionID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
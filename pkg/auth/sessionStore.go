@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// SessionStore is where sessions and the short-lived values around them (login
+// states, cached profiles, locks) are kept.
+//
+// Sessions expire at their ExpiresAt, the store is expected to forget them
+// afterwards.
+type SessionStore interface {
+	// Create stores a new session and its info under id.
+	Create(ctx context.Context, id string, s Session, info SessionInfo) error
+
+	// Get returns the session stored under id, or nil if there is none.
+	Get(ctx context.Context, id string) (*Session, error)
+
+	// Update atomically applies fn to the session stored under id and returns
+	// the result, or nil if there is no such session. If fn moves ExpiresAt, the
+	// session (and its info) expires at the new time.
+	Update(ctx context.Context, id string, fn func(s *Session)) (*Session, error)
+
+	// Delete removes the session stored under id and its info.
+	Delete(ctx context.Context, id string) error
+
+	// List returns the info of every live session belonging to a user.
+	List(ctx context.Context, userID string) ([]SessionInfo, error)
+
+	// Touch records that the session stored under id was used from ip.
+	Touch(ctx context.Context, id string, ip string) error
+
+	// Lock takes a lock on key for at most ttl. The returned unlock function is
+	// nil if someone else already holds the lock.
+	Lock(ctx context.Context, key string, ttl time.Duration) (func(), error)
+
+	// SetValue stores a short-lived value that expires after ttl.
+	SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// GetValue returns the value stored under key, or nil if there is none.
+	GetValue(ctx context.Context, key string) ([]byte, error)
+
+	// TakeValue returns and deletes the value stored under key, or returns nil
+	// if there is none. Only one caller can take a value.
+	TakeValue(ctx context.Context, key string) ([]byte, error)
+
+	// DeleteValue removes the value stored under key.
+	DeleteValue(ctx context.Context, key string) error
+}
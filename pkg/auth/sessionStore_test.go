@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionStores are the SessionStore implementations every contract test runs
+// against.
+var sessionStores = []struct {
+	name string
+	new  func(t *testing.T) SessionStore
+}{
+	{"memory", func(t *testing.T) SessionStore {
+		return NewMemorySessionStore()
+	}},
+	{"redis", func(t *testing.T) SessionStore {
+		store, _ := newTestRedisStore(t)
+		return store
+	}},
+}
+
+const OTHER_USER_ID = "876543210987654321"
+
+func createStoredSession(t *testing.T, store SessionStore, id string, userID string) Session {
+	t.Helper()
+
+	now := time.Now()
+	s := Session{
+		UserID:       userID,
+		AccessToken:  "access-" + id,
+		RefreshToken: "refresh-" + id,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Hour),
+	}
+
+	info := SessionInfo{
+		CreatedAt: now,
+		LastSeen:  now,
+		IP:        "192.0.2.1",
+		UserAgent: "test",
+	}
+
+	if err := store.Create(context.Background(), id, s, info); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func listSessionIDs(t *testing.T, store SessionStore, userID string) []string {
+	t.Helper()
+
+	infos, err := store.List(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, info := range infos {
+		if info.ID != PublicSessionID(info.SessionID) || info.UserID != userID {
+			t.Errorf("got session info %+v", info)
+		}
+
+		ids = append(ids, info.SessionID)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func assertSessionIDs(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got sessions %v, want %v", got, want)
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got sessions %v, want %v", got, want)
+		}
+	}
+}
+
+var sessionStoreTests = []struct {
+	name string
+	test func(t *testing.T, store SessionStore)
+}{
+	{"create and get", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+		want := createStoredSession(t, store, "a", TEST_USER_ID)
+
+		got, err := store.Get(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got == nil ||
+			got.UserID != want.UserID ||
+			got.AccessToken != want.AccessToken ||
+			got.RefreshToken != want.RefreshToken ||
+			!got.CreatedAt.Equal(want.CreatedAt) ||
+			!got.ExpiresAt.Equal(want.ExpiresAt) {
+			t.Errorf("Get = %+v, want %+v", got, want)
+		}
+
+		if got, err := store.Get(ctx, "missing"); got != nil || err != nil {
+			t.Errorf("Get(missing) = %+v, %v, want nil", got, err)
+		}
+	}},
+
+	{"update", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+		createStoredSession(t, store, "a", TEST_USER_ID)
+
+		updated, err := store.Update(ctx, "a", func(s *Session) {
+			s.AccessToken = "new-access"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated == nil || updated.AccessToken != "new-access" || updated.RefreshToken != "refresh-a" {
+			t.Errorf("Update = %+v, want the new access token", updated)
+		}
+
+		got, err := store.Get(ctx, "a")
+		if err != nil || got == nil || got.AccessToken != "new-access" {
+			t.Errorf("Get after Update = %+v, %v", got, err)
+		}
+
+		called := false
+		updated, err = store.Update(ctx, "missing", func(s *Session) {
+			called = true
+		})
+		if updated != nil || err != nil || called {
+			t.Errorf("Update(missing) = %+v, %v, called %v, want nothing", updated, err, called)
+		}
+	}},
+
+	{"list", func(t *testing.T, store SessionStore) {
+		createStoredSession(t, store, "a", TEST_USER_ID)
+		createStoredSession(t, store, "b", TEST_USER_ID)
+		createStoredSession(t, store, "c", OTHER_USER_ID)
+
+		assertSessionIDs(t, listSessionIDs(t, store, TEST_USER_ID), "a", "b")
+		assertSessionIDs(t, listSessionIDs(t, store, OTHER_USER_ID), "c")
+		assertSessionIDs(t, listSessionIDs(t, store, "nobody"))
+
+		infos, err := store.List(context.Background(), OTHER_USER_ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if infos[0].IP != "192.0.2.1" || infos[0].UserAgent != "test" {
+			t.Errorf("got session info %+v", infos[0])
+		}
+	}},
+
+	{"touch", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+		createStoredSession(t, store, "a", TEST_USER_ID)
+
+		before := time.Now()
+		if err := store.Touch(ctx, "a", "198.51.100.7"); err != nil {
+			t.Fatal(err)
+		}
+
+		infos, err := store.List(ctx, TEST_USER_ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the redis store keeps whole seconds
+		if len(infos) != 1 || infos[0].IP != "198.51.100.7" || infos[0].LastSeen.Before(before.Truncate(time.Second)) {
+			t.Errorf("got session info %+v after Touch", infos)
+		}
+
+		if err := store.Touch(ctx, "missing", "198.51.100.7"); err != nil {
+			t.Errorf("Touch(missing) = %v", err)
+		}
+	}},
+
+	{"delete", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+		createStoredSession(t, store, "a", TEST_USER_ID)
+		createStoredSession(t, store, "b", TEST_USER_ID)
+
+		if err := store.Delete(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := store.Get(ctx, "a"); got != nil || err != nil {
+			t.Errorf("Get after Delete = %+v, %v, want nil", got, err)
+		}
+
+		assertSessionIDs(t, listSessionIDs(t, store, TEST_USER_ID), "b")
+
+		// a late Touch or Update, e.g. from a request that was in flight when the
+		// user logged out, doesn't bring it back
+		if err := store.Touch(ctx, "a", "198.51.100.7"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := store.Update(ctx, "a", func(s *Session) {}); got != nil || err != nil {
+			t.Errorf("Update after Delete = %+v, %v, want nil", got, err)
+		}
+
+		if got, err := store.Get(ctx, "a"); got != nil || err != nil {
+			t.Errorf("Get after Touch = %+v, %v, want nil", got, err)
+		}
+
+		assertSessionIDs(t, listSessionIDs(t, store, TEST_USER_ID), "b")
+
+		if err := store.Delete(ctx, "missing"); err != nil {
+			t.Errorf("Delete(missing) = %v", err)
+		}
+	}},
+
+	{"lock", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+
+		unlock, err := store.Lock(ctx, "lock", time.Minute)
+		if err != nil || unlock == nil {
+			t.Fatalf("Lock = %v, want the lock", err)
+		}
+
+		again, err := store.Lock(ctx, "lock", time.Minute)
+		if err != nil || again != nil {
+			t.Fatalf("Lock while held = %v, want no lock", err)
+		}
+
+		other, err := store.Lock(ctx, "other", time.Minute)
+		if err != nil || other == nil {
+			t.Fatalf("Lock(other) = %v, want the lock", err)
+		}
+		other()
+
+		unlock()
+
+		again, err = store.Lock(ctx, "lock", time.Minute)
+		if err != nil || again == nil {
+			t.Fatalf("Lock after unlock = %v, want the lock", err)
+		}
+
+		// only the holder's unlock releases it
+		unlock()
+
+		if third, err := store.Lock(ctx, "lock", time.Minute); err != nil || third != nil {
+			t.Fatalf("stale unlock released the lock: %v", err)
+		}
+	}},
+
+	{"values", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+
+		if err := store.SetValue(ctx, "key", []byte("value"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := store.GetValue(ctx, "key"); string(got) != "value" || err != nil {
+			t.Errorf("GetValue = %q, %v", got, err)
+		}
+
+		if err := store.DeleteValue(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := store.GetValue(ctx, "key"); got != nil || err != nil {
+			t.Errorf("GetValue after DeleteValue = %q, %v, want nil", got, err)
+		}
+	}},
+
+	{"take value", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+
+		if err := store.SetValue(ctx, "key", []byte("value"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := store.TakeValue(ctx, "key"); string(got) != "value" || err != nil {
+			t.Errorf("TakeValue = %q, %v", got, err)
+		}
+
+		if got, err := store.TakeValue(ctx, "key"); got != nil || err != nil {
+			t.Errorf("second TakeValue = %q, %v, want nil", got, err)
+		}
+
+		if got, err := store.GetValue(ctx, "key"); got != nil || err != nil {
+			t.Errorf("GetValue after TakeValue = %q, %v, want nil", got, err)
+		}
+	}},
+
+	{"take value concurrently", func(t *testing.T, store SessionStore) {
+		ctx := context.Background()
+
+		if err := store.SetValue(ctx, "key", []byte("value"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		taken := 0
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				got, err := store.TakeValue(ctx, "key")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if got != nil {
+					mu.Lock()
+					taken++
+					mu.Unlock()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		if taken != 1 {
+			t.Errorf("value was taken %v times, want once", taken)
+		}
+	}},
+}
+
+func TestSessionStores(t *testing.T) {
+	for _, impl := range sessionStores {
+		t.Run(impl.name, func(t *testing.T) {
+			for _, tt := range sessionStoreTests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.test(t, impl.new(t))
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+func sessionUserKey(sessionID string) string {
+	return "session_user:" + sessionID
+}
+
+// GetCachedUser returns the discord profile cached for the session, or nil if
+// it expired and has to be fetched (and the token revalidated) again.
+func (m AuthManager) GetCachedUser(ctx context.Context, sessionID string) (*models.DiscordUser, error) {
+	if m.UserCacheTTL <= 0 {
+		return nil, nil
+	}
+
+	b, err := m.Store.GetValue(ctx, sessionUserKey(sessionID))
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var user models.DiscordUser
+	err = json.Unmarshal(b, &user)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to decode cached user")
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m AuthManager) SetCachedUser(ctx context.Context, sessionID string, user *models.DiscordUser) error {
+	if m.UserCacheTTL <= 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return m.Store.SetValue(ctx, sessionUserKey(sessionID), b, m.UserCacheTTL)
+}
+
+func (m AuthManager) DeleteCachedUser(ctx context.Context, sessionID string) error {
+	return m.Store.DeleteValue(ctx, sessionUserKey(sessionID))
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const TEST_USER_CACHE_TTL = time.Minute
+
+func TestAuthenticatedUsesCachedUser(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	store, mr := newTestRedisStore(t)
+	m.Store = store
+	m.UserCacheTTL = TEST_USER_CACHE_TTL
+
+	fd.valid["access"] = true
+	id := createTestSession(t, m, "access", "refresh")
+
+	assertCalls := func(want int) {
+		t.Helper()
+
+		var got *http.Request
+		if w := serveSession(m.Authenticated(seenBy(&got)), id); w.Code != http.StatusOK {
+			t.Fatalf("got status %v, want 200", w.Code)
+		}
+
+		if user := userFromContext(got); user.ID != TEST_USER_ID {
+			t.Errorf("got user %+v", user)
+		}
+
+		if users, _ := fd.calls(); users != want {
+			t.Errorf("discord was asked for the user %v times, want %v", users, want)
+		}
+	}
+
+	assertCalls(1)
+
+	// cached, discord isn't asked again
+	assertCalls(1)
+	assertCalls(1)
+
+	mr.FastForward(TEST_USER_CACHE_TTL - time.Second)
+	assertCalls(1)
+
+	// expired, the token is checked again and the user cached again
+	mr.FastForward(2 * time.Second)
+	assertCalls(2)
+	assertCalls(2)
+}
+
+func TestAuthenticatedWithoutUserCache(t *testing.T) {
+	m, _ := newTestManager(t)
+	fd, srv := newFakeDiscord(t)
+	useDiscord(m, srv)
+
+	fd.valid["access"] = true
+	id := createTestSession(t, m, "access", "refresh")
+
+	for i := 1; i <= 3; i++ {
+		var got *http.Request
+		if w := serveSession(m.Authenticated(seenBy(&got)), id); w.Code != http.StatusOK {
+			t.Fatalf("got status %v, want 200", w.Code)
+		}
+
+		if users, _ := fd.calls(); users != i {
+			t.Errorf("discord was asked for the user %v times, want %v", users, i)
+		}
+	}
+}
+
+func TestCachedUserDropped(t *testing.T) {
+	tests := []struct {
+		name string
+		drop func(m *AuthManager, id string) error
+	}{
+		{"DeleteCachedUser", func(m *AuthManager, id string) error {
+			return m.DeleteCachedUser(context.Background(), id)
+		}},
+		{"DeleteSession", func(m *AuthManager, id string) error {
+			return m.DeleteSession(context.Background(), id)
+		}},
+		{"EndSession", func(m *AuthManager, id string) error {
+			return m.EndSession(context.Background(), id)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+			_, srv := newFakeDiscord(t)
+			useDiscord(m, srv)
+
+			store, mr := newTestRedisStore(t)
+			m.Store = store
+			m.UserCacheTTL = TEST_USER_CACHE_TTL
+
+			ctx := context.Background()
+			id := createTestSession(t, m, "access", "refresh")
+
+			if err := m.SetCachedUser(ctx, id, &testUser); err != nil {
+				t.Fatal(err)
+			}
+
+			if ttl := mr.TTL(sessionUserKey(id)); !approxTTL(ttl, TEST_USER_CACHE_TTL) {
+				t.Errorf("cached user TTL = %v, want %v", ttl, TEST_USER_CACHE_TTL)
+			}
+
+			user, err := m.GetCachedUser(ctx, id)
+			if err != nil || user == nil || user.ID != TEST_USER_ID {
+				t.Fatalf("GetCachedUser = %+v, %v, want the cached user", user, err)
+			}
+
+			if err := tt.drop(m, id); err != nil {
+				t.Fatal(err)
+			}
+
+			user, err = m.GetCachedUser(ctx, id)
+			if err != nil || user != nil {
+				t.Errorf("GetCachedUser = %+v, %v, want nothing", user, err)
+			}
+
+			if mr.Exists(sessionUserKey(id)) {
+				t.Error("cached user is still in redis")
+			}
+		})
+	}
+}
@@ -2,12 +2,11 @@ package cache
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/metrics"
 	"github.com/thankyoudiscord/api/pkg/protos"
 	protobuf "google.golang.org/protobuf/proto"
 )
@@ -20,29 +19,27 @@ type BannerCache struct {
 	RedisClient *redis.Client
 }
 
-var cacheSingleton BannerCache
-
-func (bc BannerCache) Set(bannerResp *protos.CreateBannerResponse) error {
+func (bc BannerCache) Set(ctx context.Context, bannerResp *protos.CreateBannerResponse) error {
 	b, err := protobuf.Marshal(bannerResp)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to serialize banner protobuf: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to serialize banner protobuf")
 		return err
 	}
 
 	res := bc.RedisClient.Set(
-		context.Background(),
+		ctx,
 		BANNER_STALE_KEY,
 		b,
 		0,
 	)
 
 	if res.Err() != nil {
-		fmt.Fprintf(os.Stderr, "failed to set stale banner in redis: %v\n", res.Err())
+		logging.Ctx(ctx).Error().Err(res.Err()).Msg("failed to set stale banner in redis")
 		return res.Err()
 	}
 
 	res = bc.RedisClient.SetEX(
-		context.Background(),
+		ctx,
 		BANNER_CACHE_KEY,
 		b,
 		BANNER_CACHE_TTL,
@@ -51,17 +48,34 @@ func (bc BannerCache) Set(bannerResp *protos.CreateBannerResponse) error {
 	return res.Err()
 }
 
-func (bc BannerCache) Get() (*protos.CreateBannerResponse, bool, error) {
+// Get returns the cached banner and whether it should be regenerated, which is
+// the case when only the stale banner or no banner at all is cached.
+func (bc BannerCache) Get(ctx context.Context) (*protos.CreateBannerResponse, bool, error) {
+	msg, shouldRegen, err := bc.get(ctx)
+
+	switch {
+	case msg == nil:
+		metrics.BannerCacheLookups.WithLabelValues(metrics.CACHE_MISS).Inc()
+	case shouldRegen:
+		metrics.BannerCacheLookups.WithLabelValues(metrics.CACHE_STALE).Inc()
+	default:
+		metrics.BannerCacheLookups.WithLabelValues(metrics.CACHE_HIT).Inc()
+	}
+
+	return msg, shouldRegen, err
+}
+
+func (bc BannerCache) get(ctx context.Context) (*protos.CreateBannerResponse, bool, error) {
 	shouldRegen := true
-	res := bc.RedisClient.Get(context.Background(), BANNER_CACHE_KEY)
+	res := bc.RedisClient.Get(ctx, BANNER_CACHE_KEY)
 	if err := res.Err(); err != nil {
 		if err == redis.Nil {
-			res = bc.RedisClient.Get(context.Background(), BANNER_STALE_KEY)
+			res = bc.RedisClient.Get(ctx, BANNER_STALE_KEY)
 			if res.Err() == redis.Nil {
 				return nil, shouldRegen, nil
 			}
 		} else {
-			fmt.Fprintf(os.Stderr, "failed to get banner from redis: %v\n", err)
+			logging.Ctx(ctx).Error().Err(err).Msg("failed to get banner from redis")
 			return nil, shouldRegen, err
 		}
 	} else {
@@ -80,23 +94,22 @@ func (bc BannerCache) Get() (*protos.CreateBannerResponse, bool, error) {
 	var msg protos.CreateBannerResponse
 	err = protobuf.Unmarshal(b, &msg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to deserialize cached banner from redis: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to deserialize cached banner from redis")
 		return nil, shouldRegen, err
 	}
 
 	return &msg, shouldRegen, nil
 }
 
-var initOnce sync.Once
-
-func InitBannerCache(r *redis.Client) {
-	initOnce.Do(func() {
-		cacheSingleton = BannerCache{
-			RedisClient: r,
-		}
-	})
+// Invalidate forces the banner to be regenerated on the next request. The stale
+// banner is kept so it can still be served in the meantime.
+func (bc BannerCache) Invalidate(ctx context.Context) error {
+	res := bc.RedisClient.Del(ctx, BANNER_CACHE_KEY)
+	return res.Err()
 }
 
-func GetBannerCache() BannerCache {
-	return cacheSingleton
+func NewBannerCache(r *redis.Client) BannerCache {
+	return BannerCache{
+		RedisClient: r,
+	}
 }
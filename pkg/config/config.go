@@ -0,0 +1,492 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const DEFAULT_CONFIG_FILE = ".env"
+
+// REDACTED replaces secrets in Values and in the logs.
+const REDACTED = "[redacted]"
+
+// Config is the API's whole configuration. Every field is read from the
+// variable named by its env tag, see Load for where values come from.
+type Config struct {
+	AppEnv   string `env:"APP_ENV" default:"development"`
+	Addr     string `env:"ADDR" default:":3000"`
+	LogLevel string `env:"LOG_LEVEL" default:"info"`
+
+	// IPs or CIDRs of the proxies in front of the API. X-Forwarded-For and
+	// X-Real-IP are ignored unless the request comes from one of them.
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+
+	ReadTimeout  time.Duration `env:"HTTP_READ_TIMEOUT" default:"10s"`
+	WriteTimeout time.Duration `env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	IdleTimeout  time.Duration `env:"HTTP_IDLE_TIMEOUT" default:"2m"`
+
+	// on SIGTERM the server keeps serving for ShutdownDelay so load balancers
+	// can stop sending traffic, then waits up to ShutdownTimeout for in-flight
+	// requests
+	ShutdownDelay   time.Duration `env:"SHUTDOWN_DELAY" default:"5s"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"30s"`
+
+	// prometheus metrics are served on their own listener, which shouldn't be
+	// reachable from the internet
+	MetricsAddr string `env:"METRICS_ADDR" default:":9090"`
+
+	ClientID     string `env:"CLIENT_ID" required:"true"`
+	ClientSecret string `env:"CLIENT_SECRET" required:"true" secret:"true"`
+	RedirectURI  string `env:"REDIRECT_URI" required:"true"`
+
+	DiscordAPIURL string `env:"DISCORD_API_URL" default:"https://discord.com/api"`
+
+	RedisHost string `env:"REDIS_HOST" required:"true"`
+	RedisPort string `env:"REDIS_PORT" required:"true"`
+
+	// postgres, or memory to keep everything in process for local development
+	DatabaseStore string `env:"DATABASE_STORE" default:"postgres"`
+
+	// required when DatabaseStore is postgres
+	PostgresHost     string `env:"POSTGRES_HOST"`
+	PostgresPort     string `env:"POSTGRES_PORT"`
+	PostgresUser     string `env:"POSTGRES_USER"`
+	PostgresPassword string `env:"POSTGRES_PASSWORD" secret:"true"`
+	PostgresDB       string `env:"POSTGRES_DB"`
+	MigrateOnStartup bool   `env:"MIGRATE_ON_STARTUP"`
+
+	BannerGRPCAddr string `env:"BANNER_GRPC_ADDR" required:"true"`
+
+	UserCacheTTL       time.Duration `env:"USER_CACHE_TTL" default:"5m"`
+	SessionMaxLifetime time.Duration `env:"SESSION_MAX_LIFETIME" default:"2160h"`
+
+	SessionStore          string `env:"SESSION_STORE" default:"redis"`
+	SessionEncryptionKeys string `env:"SESSION_ENCRYPTION_KEYS" secret:"true"`
+
+	AdminUserIDs   []string `env:"ADMIN_USER_IDS"`
+	BansBlockLogin bool     `env:"BANS_BLOCK_LOGIN"`
+
+	CaptchaSecret    string `env:"CAPTCHA_SECRET" secret:"true"`
+	CaptchaVerifyURL string `env:"CAPTCHA_VERIFY_URL"`
+
+	SignatureFeedWebhook string `env:"SIGNATURE_FEED_WEBHOOK" secret:"true"`
+
+	// spans are exported over OTLP/gRPC when an endpoint is set
+	OTLPEndpoint     string  `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OTLPInsecure     bool    `env:"OTEL_EXPORTER_OTLP_INSECURE"`
+	ServiceName      string  `env:"OTEL_SERVICE_NAME" default:"thankyoudiscord-api"`
+	TraceSampleRatio float64 `env:"TRACE_SAMPLE_RATIO" default:"1"`
+
+	// all three are needed to give signers a role
+	DiscordToken         string `env:"DISCORD_TOKEN" secret:"true"`
+	SignatureRole        string `env:"SIGNATURE_ROLE"`
+	SignatureRoleGuildID string `env:"SIGNATURE_ROLE_GUILD_ID"`
+}
+
+// settings needed to connect to postgres
+var postgresSettings = []string{
+	"POSTGRES_HOST",
+	"POSTGRES_PORT",
+	"POSTGRES_USER",
+	"POSTGRES_PASSWORD",
+	"POSTGRES_DB",
+}
+
+// settings that only make sense together, either all or none must be set
+var dependentSettings = [][]string{
+	{"DISCORD_TOKEN", "SIGNATURE_ROLE", "SIGNATURE_ROLE_GUILD_ID"},
+	{"CAPTCHA_SECRET", "CAPTCHA_VERIFY_URL"},
+}
+
+func (c *Config) IsProduction() bool {
+	return c.AppEnv == "production"
+}
+
+// AssignsSignatureRole reports whether signers should be given a role.
+func (c *Config) AssignsSignatureRole() bool {
+	return c.DiscordToken != ""
+}
+
+// TrustedProxyNetworks parses TrustedProxies, single IPs become /32 or /128
+// networks.
+func (c *Config) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, p := range c.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", p)
+			}
+
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func (c *Config) RedisAddr() string {
+	return c.RedisHost + ":" + c.RedisPort
+}
+
+func (c *Config) PostgresURL() string {
+	u := url.URL{
+		User:   url.UserPassword(c.PostgresUser, c.PostgresPassword),
+		Scheme: "postgres",
+		Host:   c.PostgresHost + ":" + c.PostgresPort,
+		Path:   c.PostgresDB,
+		RawQuery: url.Values{
+			"sslmode":  {"disable"},
+			"TimeZone": {"America/New_York"},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// Load builds the config from, in increasing order of precedence, defaults,
+// the config file, the environment and command line flags. Every setting has a
+// flag named after its variable, e.g. -redis-host for REDIS_HOST, and
+// -config picks the file (default .env, which may be missing).
+//
+// Any setting can instead be read from a file by setting the variable with a
+// _FILE suffix, e.g. POSTGRES_PASSWORD_FILE=/run/secrets/pg. It takes the place
+// of the variable in the source that sets it, so an environment variable still
+// beats a _FILE in the config file.
+//
+// Empty values count as unset everywhere, like in the .env.sample, so they
+// fall through to the next source.
+//
+// Load returns the arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("thankyoudiscord", flag.ContinueOnError)
+
+	configFile := fs.String("config", DEFAULT_CONFIG_FILE, "config file in .env format")
+
+	fields := configFields()
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		flagValues[f.key] = fs.String(f.flagName(), "", "sets "+f.key)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	fileValues := map[string]string{}
+	if _, err := os.Stat(*configFile); err == nil || setFlags["config"] {
+		fileValues, err = godotenv.Read(*configFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read config file %v: %w", *configFile, err)
+		}
+	}
+
+	// in decreasing order of precedence
+	sources := []func(key string) string{
+		os.Getenv,
+		func(key string) string {
+			return fileValues[key]
+		},
+	}
+
+	c := &Config{}
+	v := reflect.ValueOf(c).Elem()
+
+	var errs []string
+	for _, f := range fields {
+		raw, ok, err := f.resolve(sources)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if setFlags[f.flagName()] && *flagValues[f.key] != "" {
+			raw, ok = *flagValues[f.key], true
+		}
+
+		if !ok {
+			raw = f.def
+		}
+
+		if err := setField(v.Field(f.index), raw); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %v: %v", f.key, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = c.validate()
+	}
+
+	if len(errs) != 0 {
+		return nil, nil, errors.New(strings.Join(errs, "; "))
+	}
+
+	return c, fs.Args(), nil
+}
+
+func (c *Config) validate() []string {
+	var errs []string
+
+	values := map[string]string{}
+	for _, f := range configFields() {
+		values[f.key] = f.format(c)
+	}
+
+	var missing []string
+	for _, f := range configFields() {
+		if f.required && values[f.key] == "" {
+			missing = append(missing, f.key)
+		}
+	}
+
+	if len(missing) != 0 {
+		errs = append(errs, "missing "+strings.Join(missing, ", "))
+	}
+
+	for _, group := range dependentSettings {
+		var set, unset []string
+		for _, key := range group {
+			if values[key] == "" {
+				unset = append(unset, key)
+			} else {
+				set = append(set, key)
+			}
+		}
+
+		if len(set) != 0 && len(unset) != 0 {
+			errs = append(errs, fmt.Sprintf(
+				"%v must be set together, missing %v",
+				strings.Join(group, ", "),
+				strings.Join(unset, ", "),
+			))
+		}
+	}
+
+	switch c.DatabaseStore {
+	case "postgres":
+		var missing []string
+		for _, key := range postgresSettings {
+			if values[key] == "" {
+				missing = append(missing, key)
+			}
+		}
+
+		if len(missing) != 0 {
+			errs = append(errs, fmt.Sprintf(
+				"%v are required when DATABASE_STORE is postgres, missing %v",
+				strings.Join(postgresSettings, ", "),
+				strings.Join(missing, ", "),
+			))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Sprintf("DATABASE_STORE must be postgres or memory, got %q", c.DatabaseStore))
+	}
+
+	switch c.SessionStore {
+	case "redis":
+		if c.SessionEncryptionKeys == "" {
+			errs = append(errs, "SESSION_ENCRYPTION_KEYS is required when SESSION_STORE is redis")
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Sprintf("SESSION_STORE must be redis or memory, got %q", c.SessionStore))
+	}
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+
+	if _, err := c.TrustedProxyNetworks(); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid TRUSTED_PROXIES: %v", err))
+	}
+
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		errs = append(errs, "TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+
+	// signatures are only captcha checked in production
+	if c.IsProduction() && c.CaptchaSecret == "" {
+		errs = append(errs, "CAPTCHA_SECRET and CAPTCHA_VERIFY_URL are required in production")
+	}
+
+	return errs
+}
+
+// Values returns the effective configuration keyed by variable, with secrets
+// redacted.
+func (c *Config) Values() map[string]string {
+	values := map[string]string{}
+	for _, f := range configFields() {
+		val := f.format(c)
+		if f.secret && val != "" {
+			val = REDACTED
+		}
+
+		values[f.key] = val
+	}
+
+	return values
+}
+
+// Secrets returns the values of all settings marked secret that are set.
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, f := range configFields() {
+		if val := f.format(c); f.secret && val != "" {
+			secrets = append(secrets, val)
+		}
+	}
+
+	return secrets
+}
+
+type configField struct {
+	index    int
+	key      string
+	def      string
+	required bool
+	secret   bool
+}
+
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fields = append(fields, configField{
+			index:    i,
+			key:      sf.Tag.Get("env"),
+			def:      sf.Tag.Get("default"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+		})
+	}
+
+	return fields
+}
+
+func (f configField) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.key), "_", "-")
+}
+
+// resolve looks up the field's raw value in the first source that sets it,
+// reading it from the file named by KEY_FILE if the source sets that instead.
+func (f configField) resolve(sources []func(key string) string) (string, bool, error) {
+	for _, lookup := range sources {
+		if path := lookup(f.key + "_FILE"); path != "" {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return "", false, fmt.Errorf("failed to read %v_FILE: %v", f.key, err)
+			}
+
+			if v := strings.TrimSpace(string(b)); v != "" {
+				return v, true, nil
+			}
+
+			continue
+		}
+
+		if v := lookup(f.key); v != "" {
+			return v, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+func (f configField) format(c *Config) string {
+	v := reflect.ValueOf(c).Elem().Field(f.index)
+
+	switch val := v.Interface().(type) {
+	case []string:
+		return strings.Join(val, ",")
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func setField(v reflect.Value, raw string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(raw)
+
+	case bool:
+		if raw == "" {
+			v.SetBool(false)
+			return nil
+		}
+
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+
+	case time.Duration:
+		if raw == "" {
+			v.SetInt(0)
+			return nil
+		}
+
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+
+	case float64:
+		if raw == "" {
+			v.SetFloat(0)
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+
+	case []string:
+		v.Set(reflect.ValueOf(strings.Fields(strings.ReplaceAll(raw, ",", " "))))
+
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,473 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a base64 encoded 32 byte key
+const testSessionKey = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+
+var requiredSettings = map[string]string{
+	"CLIENT_ID":               "client-id",
+	"CLIENT_SECRET":           "client-secret",
+	"REDIRECT_URI":            "http://localhost:3000/auth/callback",
+	"REDIS_HOST":              "localhost",
+	"REDIS_PORT":              "6379",
+	"POSTGRES_HOST":           "localhost",
+	"POSTGRES_PORT":           "5432",
+	"POSTGRES_USER":           "postgres",
+	"POSTGRES_PASSWORD":       "postgres-password",
+	"POSTGRES_DB":             "thankyoudiscord",
+	"BANNER_GRPC_ADDR":        "localhost:50051",
+	"SESSION_ENCRYPTION_KEYS": testSessionKey,
+}
+
+// clearEnv blanks every setting in the environment, which Load treats as
+// unset, so the tests don't pick up the developer's own config.
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	for _, f := range configFields() {
+		t.Setenv(f.key, "")
+		t.Setenv(f.key+"_FILE", "")
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// configLines formats every required setting, overridden and extended by
+// settings, as a config file. An empty value leaves a setting out.
+func configLines(settings map[string]string) string {
+	values := map[string]string{}
+	for k, v := range requiredSettings {
+		values[k] = v
+	}
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	var lines []string
+	for k, v := range values {
+		if v != "" {
+			lines = append(lines, k+"="+v)
+		}
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func writeConfig(t *testing.T, settings map[string]string) string {
+	t.Helper()
+
+	return writeFile(t, ".env", configLines(settings))
+}
+
+func load(t *testing.T, settings map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+
+	c, _, err := Load(append([]string{"-config", writeConfig(t, settings)}, args...))
+	return c, err
+}
+
+func mustLoad(t *testing.T, settings map[string]string, args ...string) *Config {
+	t.Helper()
+
+	c, err := load(t, settings, args...)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	return c
+}
+
+func assertLoadError(t *testing.T, err error, want ...string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("Load succeeded, want an error mentioning %v", want)
+	}
+
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("Load error %q doesn't mention %q", err, w)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  string
+		flag string
+		want string
+	}{
+		{name: "default", want: ":3000"},
+		{name: "file over default", file: ":3001", want: ":3001"},
+		{name: "env over file", file: ":3001", env: ":3002", want: ":3002"},
+		{name: "flag over env", file: ":3001", env: ":3002", flag: ":3003", want: ":3003"},
+		{name: "flag over default", flag: ":3003", want: ":3003"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("ADDR", tt.env)
+
+			var args []string
+			if tt.flag != "" {
+				args = append(args, "-addr", tt.flag)
+			}
+
+			c := mustLoad(t, map[string]string{"ADDR": tt.file}, args...)
+			if c.Addr != tt.want {
+				t.Errorf("Addr = %q, want %q", c.Addr, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmptyValuesAreUnset(t *testing.T) {
+	clearEnv(t)
+
+	// blank in the environment, on the command line and in the file
+	t.Setenv("REDIS_PORT", "")
+	t.Setenv("SHUTDOWN_DELAY", "")
+	path := writeFile(t, ".env", configLines(map[string]string{"REDIS_PORT": "6380"})+"SHUTDOWN_DELAY=\n")
+
+	c, _, err := Load([]string{"-config", path, "-redis-port", "", "-shutdown-delay="})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.RedisPort != "6380" {
+		t.Errorf("RedisPort = %q, want the file's 6380", c.RedisPort)
+	}
+
+	if c.ShutdownDelay != 5*time.Second {
+		t.Errorf("ShutdownDelay = %v, want the default 5s", c.ShutdownDelay)
+	}
+}
+
+func TestLoadFileSuffix(t *testing.T) {
+	t.Run("read and trimmed", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("POSTGRES_PASSWORD_FILE", writeFile(t, "pg", "  from-file\n"))
+
+		c := mustLoad(t, nil)
+		if c.PostgresPassword != "from-file" {
+			t.Errorf("PostgresPassword = %q, want %q", c.PostgresPassword, "from-file")
+		}
+	})
+
+	t.Run("in the config file", func(t *testing.T) {
+		clearEnv(t)
+
+		c := mustLoad(t, map[string]string{
+			"POSTGRES_PASSWORD":      "",
+			"POSTGRES_PASSWORD_FILE": writeFile(t, "pg", "from-file"),
+		})
+		if c.PostgresPassword != "from-file" {
+			t.Errorf("PostgresPassword = %q, want %q", c.PostgresPassword, "from-file")
+		}
+	})
+
+	t.Run("env value over config file's _FILE", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("POSTGRES_PASSWORD", "from-env")
+
+		c := mustLoad(t, map[string]string{
+			"POSTGRES_PASSWORD_FILE": writeFile(t, "pg", "from-file"),
+		})
+		if c.PostgresPassword != "from-env" {
+			t.Errorf("PostgresPassword = %q, want %q", c.PostgresPassword, "from-env")
+		}
+	})
+
+	t.Run("flag over _FILE", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("POSTGRES_PASSWORD_FILE", writeFile(t, "pg", "from-file"))
+
+		c := mustLoad(t, nil, "-postgres-password", "from-flag")
+		if c.PostgresPassword != "from-flag" {
+			t.Errorf("PostgresPassword = %q, want %q", c.PostgresPassword, "from-flag")
+		}
+	})
+
+	t.Run("empty file is unset", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("SHUTDOWN_DELAY_FILE", writeFile(t, "delay", "\n"))
+
+		c := mustLoad(t, map[string]string{"SHUTDOWN_DELAY": "1s"})
+		if c.ShutdownDelay != time.Second {
+			t.Errorf("ShutdownDelay = %v, want the file's 1s", c.ShutdownDelay)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("POSTGRES_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		_, err := load(t, nil)
+		assertLoadError(t, err, "POSTGRES_PASSWORD_FILE")
+	})
+}
+
+func TestLoadMissingConfigFile(t *testing.T) {
+	clearEnv(t)
+	for k, v := range requiredSettings {
+		t.Setenv(k, v)
+	}
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// the default .env may be missing
+	if _, _, err := Load(nil); err != nil {
+		t.Errorf("Load without a .env: %v", err)
+	}
+
+	// but not one asked for
+	if _, _, err := Load([]string{"-config", filepath.Join(dir, "missing.env")}); err == nil {
+		t.Error("Load with a missing -config file succeeded")
+	}
+}
+
+func TestLoadRequired(t *testing.T) {
+	clearEnv(t)
+
+	_, err := load(t, map[string]string{"CLIENT_ID": "", "REDIS_HOST": ""})
+	assertLoadError(t, err, "missing CLIENT_ID, REDIS_HOST")
+}
+
+func TestLoadDatabaseStore(t *testing.T) {
+	clearEnv(t)
+
+	_, err := load(t, map[string]string{"POSTGRES_DB": "", "POSTGRES_USER": ""})
+	assertLoadError(t, err, "required when DATABASE_STORE is postgres, missing POSTGRES_USER, POSTGRES_DB")
+
+	// postgres settings aren't needed without postgres
+	settings := map[string]string{"DATABASE_STORE": "memory"}
+	for _, key := range postgresSettings {
+		settings[key] = ""
+	}
+
+	c := mustLoad(t, settings)
+	if c.DatabaseStore != "memory" {
+		t.Errorf("DatabaseStore = %q, want memory", c.DatabaseStore)
+	}
+
+	_, err = load(t, map[string]string{"DATABASE_STORE": "sqlite"})
+	assertLoadError(t, err, "DATABASE_STORE must be postgres or memory")
+}
+
+func TestLoadDependentSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		err      string
+	}{
+		{
+			name:     "role token alone",
+			settings: map[string]string{"DISCORD_TOKEN": "token"},
+			err:      "missing SIGNATURE_ROLE, SIGNATURE_ROLE_GUILD_ID",
+		},
+		{
+			name: "role settings together",
+			settings: map[string]string{
+				"DISCORD_TOKEN":           "token",
+				"SIGNATURE_ROLE":          "role",
+				"SIGNATURE_ROLE_GUILD_ID": "guild",
+			},
+		},
+		{
+			name:     "captcha secret alone",
+			settings: map[string]string{"CAPTCHA_SECRET": "captcha-secret"},
+			err:      "missing CAPTCHA_VERIFY_URL",
+		},
+		{
+			name:     "captcha url alone",
+			settings: map[string]string{"CAPTCHA_VERIFY_URL": "https://captcha.example/verify"},
+			err:      "missing CAPTCHA_SECRET",
+		},
+		{
+			name: "captcha settings together",
+			settings: map[string]string{
+				"CAPTCHA_SECRET":     "captcha-secret",
+				"CAPTCHA_VERIFY_URL": "https://captcha.example/verify",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+
+			_, err := load(t, tt.settings)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Load: %v", err)
+				}
+				return
+			}
+
+			assertLoadError(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadProductionRequiresCaptcha(t *testing.T) {
+	clearEnv(t)
+
+	_, err := load(t, map[string]string{"APP_ENV": "production"})
+	assertLoadError(t, err, "required in production")
+
+	c := mustLoad(t, map[string]string{
+		"APP_ENV":            "production",
+		"CAPTCHA_SECRET":     "captcha-secret",
+		"CAPTCHA_VERIFY_URL": "https://captcha.example/verify",
+	})
+	if !c.IsProduction() {
+		t.Error("IsProduction = false")
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		err      string
+	}{
+		{"redis sessions without keys", map[string]string{"SESSION_ENCRYPTION_KEYS": ""}, "SESSION_ENCRYPTION_KEYS"},
+		{"unknown session store", map[string]string{"SESSION_STORE": "disk"}, "SESSION_STORE"},
+		{"unknown log level", map[string]string{"LOG_LEVEL": "verbose"}, "LOG_LEVEL"},
+		{"invalid trusted proxy", map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,proxy"}, "TRUSTED_PROXIES"},
+		{"sample ratio out of range", map[string]string{"TRACE_SAMPLE_RATIO": "1.5"}, "TRACE_SAMPLE_RATIO"},
+		{"invalid duration", map[string]string{"USER_CACHE_TTL": "5"}, "invalid USER_CACHE_TTL"},
+		{"invalid bool", map[string]string{"BANS_BLOCK_LOGIN": "maybe"}, "invalid BANS_BLOCK_LOGIN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+
+			_, err := load(t, tt.settings)
+			assertLoadError(t, err, tt.err)
+		})
+	}
+
+	t.Run("memory sessions without keys", func(t *testing.T) {
+		clearEnv(t)
+
+		mustLoad(t, map[string]string{"SESSION_STORE": "memory", "SESSION_ENCRYPTION_KEYS": ""})
+	})
+}
+
+func TestLoadParsesTypes(t *testing.T) {
+	clearEnv(t)
+
+	c := mustLoad(t, map[string]string{
+		"ADMIN_USER_IDS":     "1, 2,,3",
+		"BANS_BLOCK_LOGIN":   "true",
+		"USER_CACHE_TTL":     "90s",
+		"TRACE_SAMPLE_RATIO": "0.25",
+		"TRUSTED_PROXIES":    "10.0.0.1,fd00::/8",
+	})
+
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(c.AdminUserIDs, want) {
+		t.Errorf("AdminUserIDs = %q, want %q", c.AdminUserIDs, want)
+	}
+
+	if !c.BansBlockLogin {
+		t.Error("BansBlockLogin = false")
+	}
+
+	if c.UserCacheTTL != 90*time.Second {
+		t.Errorf("UserCacheTTL = %v, want 90s", c.UserCacheTTL)
+	}
+
+	if c.TraceSampleRatio != 0.25 {
+		t.Errorf("TraceSampleRatio = %v, want 0.25", c.TraceSampleRatio)
+	}
+
+	nets, err := c.TrustedProxyNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nets) != 2 || nets[0].String() != "10.0.0.1/32" || nets[1].String() != "fd00::/8" {
+		t.Errorf("TrustedProxyNetworks = %v, want [10.0.0.1/32 fd00::/8]", nets)
+	}
+}
+
+func TestLoadReturnsRemainingArgs(t *testing.T) {
+	clearEnv(t)
+
+	_, args, err := Load([]string{"-config", writeConfig(t, nil), "-addr", ":4000", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"migrate", "up"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
+
+func TestValuesRedactsSecrets(t *testing.T) {
+	clearEnv(t)
+
+	c := mustLoad(t, map[string]string{"SIGNATURE_FEED_WEBHOOK": "https://discord.com/api/webhooks/1/token"})
+	values := c.Values()
+
+	for _, key := range []string{"CLIENT_SECRET", "POSTGRES_PASSWORD", "SESSION_ENCRYPTION_KEYS", "SIGNATURE_FEED_WEBHOOK"} {
+		if values[key] != REDACTED {
+			t.Errorf("Values()[%v] = %q, want it redacted", key, values[key])
+		}
+	}
+
+	if values["CLIENT_ID"] != "client-id" {
+		t.Errorf("Values()[CLIENT_ID] = %q, want %q", values["CLIENT_ID"], "client-id")
+	}
+
+	// unset secrets are shown as unset
+	if values["DISCORD_TOKEN"] != "" {
+		t.Errorf("Values()[DISCORD_TOKEN] = %q, want empty", values["DISCORD_TOKEN"])
+	}
+
+	secrets := c.Secrets()
+	sort.Strings(secrets)
+	want := []string{
+		testSessionKey,
+		"client-secret",
+		"https://discord.com/api/webhooks/1/token",
+		"postgres-password",
+	}
+	sort.Strings(want)
+
+	if !reflect.DeepEqual(secrets, want) {
+		t.Errorf("Secrets() = %q, want %q", secrets, want)
+	}
+}
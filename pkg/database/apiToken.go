@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIToken lets non-browser clients authenticate as a user. Only a hash of the
+// token is stored, the token itself is shown once when it is created.
+type APIToken struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"-"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	UserID     string     `json:"-" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"not null"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes     string     `json:"-" gorm:"not null"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// ScopeList returns the token's space separated scopes.
+func (t APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func CreateAPIToken(db *gorm.DB, t *APIToken) error {
+	return db.Create(t).Error
+}
+
+// ListAPITokens returns the user's tokens newest first.
+func ListAPITokens(db *gorm.DB, userID string) ([]APIToken, error) {
+	tokens := []APIToken{}
+
+	res := db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&tokens)
+
+	return tokens, res.Error
+}
+
+// RevokeAPIToken deletes one of the user's tokens. It returns false if the user
+// has no token with that ID.
+func RevokeAPIToken(db *gorm.DB, userID string, id uint) (bool, error) {
+	res := db.Where("user_id = ? AND id = ?", userID, id).
+		Delete(&APIToken{})
+
+	return res.RowsAffected != 0, res.Error
+}
+
+// GetAPITokenByHash returns the unrevoked token with the hash, or nil.
+func GetAPITokenByHash(db *gorm.DB, hash string) (*APIToken, error) {
+	var t APIToken
+	res := db.Where("token_hash = ?", hash).First(&t)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, res.Error
+	}
+
+	return &t, nil
+}
+
+func TouchAPIToken(db *gorm.DB, id uint, usedAt time.Time) error {
+	return db.Model(&APIToken{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
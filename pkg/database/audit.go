@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AUDIT_LOGIN             = "auth.login"
+	AUDIT_LOGOUT            = "auth.logout"
+	AUDIT_SESSION_REVOKE    = "session.revoke"
+	AUDIT_SESSIONS_REVOKE   = "session.revoke_all"
+	AUDIT_TOKEN_CREATE      = "token.create"
+	AUDIT_TOKEN_REVOKE      = "token.revoke"
+	AUDIT_SIGNATURE_CREATE  = "signature.create"
+	AUDIT_SIGNATURE_DELETE  = "signature.delete"
+	AUDIT_SIGNATURE_REMOVE  = "signature.remove"
+	AUDIT_SIGNATURE_RESTORE = "signature.restore"
+	AUDIT_BAN_CREATE        = "ban.create"
+	AUDIT_BAN_DELETE        = "ban.delete"
+	AUDIT_ROLE_SET          = "role.set"
+	AUDIT_ROLE_REMOVE       = "role.remove"
+)
+
+// JSONB is raw JSON stored in a jsonb column.
+type JSONB json.RawMessage
+
+func (j JSONB) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+
+	return string(j), nil
+}
+
+func (j *JSONB) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSONB(v)
+	default:
+		return errors.New("unsupported type for JSONB")
+	}
+
+	return nil
+}
+
+func (j JSONB) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+
+	return j, nil
+}
+
+func (j *JSONB) UnmarshalJSON(b []byte) error {
+	*j = append((*j)[:0], b...)
+	return nil
+}
+
+// AuditEvent records a state changing operation. Events are only ever
+// inserted, never updated or deleted.
+type AuditEvent struct {
+	ID        uint64    `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+
+	// the user who did it, nil for the system
+	ActorID  *string `json:"actor_id" gorm:"index"`
+	Action   string  `json:"action" gorm:"index;not null"`
+	Target   string  `json:"target" gorm:"index"`
+	IP       string  `json:"ip"`
+	Metadata JSONB   `json:"metadata" gorm:"type:jsonb"`
+}
+
+func RecordAuditEvent(db *gorm.DB, e *AuditEvent) error {
+	return db.Create(e).Error
+}
+
+// AuditFilter narrows down ListAuditEvents. Zero values don't filter.
+type AuditFilter struct {
+	ActorID string
+	Action  string
+	Target  string
+	From    *time.Time
+	To      *time.Time
+
+	// only return events older than the event with this ID
+	Before uint64
+	Limit  int
+}
+
+// ListAuditEvents returns events matching the filter, newest first. Pass the
+// ID of the last event as Before to get the next page.
+func ListAuditEvents(db *gorm.DB, f AuditFilter) ([]AuditEvent, error) {
+	q := db.Model(&AuditEvent{})
+
+	if f.ActorID != "" {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+
+	if f.Target != "" {
+		q = q.Where("target = ?", f.Target)
+	}
+
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+
+	if f.To != nil {
+		q = q.Where("created_at < ?", *f.To)
+	}
+
+	if f.Before != 0 {
+		q = q.Where("id < ?", f.Before)
+	}
+
+	events := []AuditEvent{}
+	res := q.Order("id DESC").Limit(f.Limit).Find(&events)
+	return events, res.Error
+}
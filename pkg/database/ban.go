@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ban keeps a user from signing the banner until it expires. Bans without an
+// expiry are permanent.
+type Ban struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID    string     `json:"user_id" gorm:"uniqueIndex;not null"`
+	Reason    string     `json:"reason" gorm:"not null"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	BannedBy  string     `json:"banned_by" gorm:"not null"`
+}
+
+func (b Ban) Active() bool {
+	return b.ExpiresAt == nil || b.ExpiresAt.After(time.Now())
+}
+
+// GetActiveBan returns the user's ban if they are currently banned, or nil.
+func GetActiveBan(db *gorm.DB, userID string) (*Ban, error) {
+	var ban Ban
+	res := db.Where("user_id = ?", userID).First(&ban)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, res.Error
+	}
+
+	if !ban.Active() {
+		return nil, nil
+	}
+
+	return &ban, nil
+}
+
+// BanUser bans a user, replacing any ban they already had.
+func BanUser(db *gorm.DB, ban *Ban) error {
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason", "expires_at", "banned_by", "updated_at"}),
+	}).Create(ban)
+
+	return res.Error
+}
+
+// UnbanUser lifts a user's ban. It returns false if they weren't banned.
+func UnbanUser(db *gorm.DB, userID string) (bool, error) {
+	res := db.Where("user_id = ?", userID).Delete(&Ban{})
+	return res.RowsAffected != 0, res.Error
+}
+
+func ListBans(db *gorm.DB, includeExpired bool) ([]Ban, error) {
+	q := db.Order("created_at DESC")
+	if !includeExpired {
+		q = q.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+	}
+
+	bans := []Ban{}
+	res := q.Find(&bans)
+	return bans, res.Error
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects to TEST_POSTGRES_URL and migrates a fresh schema, skipping
+// the test if it isn't set. The public schema is dropped, so it must point at a
+// throwaway database.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+
+	db, err := gorm.Open(postgres.Open(url), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, stmt := range []string{"DROP SCHEMA public CASCADE", "CREATE SCHEMA public"} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to reset schema: %v", err)
+		}
+	}
+
+	if err := MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	return db
+}
+
+// createSigners creates a user and a signature for each ID, in order.
+func createSigners(t *testing.T, db *gorm.DB, userIDs ...string) {
+	t.Helper()
+
+	for _, id := range userIDs {
+		if err := db.Create(&User{UserID: id, Username: id, Discriminator: "0001"}).Error; err != nil {
+			t.Fatalf("failed to create user %v: %v", id, err)
+		}
+
+		if err := CreateSignature(db, &Signature{UserID: id}); err != nil {
+			t.Fatalf("failed to create signature for %v: %v", id, err)
+		}
+	}
+}
+
+// positions returns every signature's position by user ID, 0 for removed
+// signatures.
+func positions(t *testing.T, db *gorm.DB) map[string]int64 {
+	t.Helper()
+
+	var sigs []Signature
+	if err := db.Unscoped().Find(&sigs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	pos := map[string]int64{}
+	for _, s := range sigs {
+		pos[s.UserID] = 0
+		if s.Position != nil {
+			pos[s.UserID] = *s.Position
+		}
+	}
+
+	return pos
+}
+
+func lastPosition(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+
+	var last int64
+	err := db.Raw("SELECT last_position FROM signature_position_counter WHERE id = 1").Scan(&last).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return last
+}
+
+func assertPositions(t *testing.T, db *gorm.DB, want map[string]int64) {
+	t.Helper()
+
+	got := positions(t, db)
+	if len(got) != len(want) {
+		t.Errorf("got %v signatures, want %v: %v", len(got), len(want), got)
+	}
+
+	var live int64
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("position of %v = %v, want %v", id, got[id], w)
+		}
+
+		if w != 0 {
+			live++
+		}
+	}
+
+	if last := lastPosition(t, db); last != live {
+		t.Errorf("last_position = %v, want %v", last, live)
+	}
+}
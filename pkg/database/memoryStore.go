@@ -0,0 +1,568 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore implements Store in process memory, for tests and local
+// development.
+type MemoryStore struct {
+	mu     sync.Mutex
+	nextID uint
+
+	users map[string]User
+
+	// removed signatures are kept with DeletedAt set and no position, like
+	// the soft deleted rows in postgres
+	signatures map[string]Signature
+	actions    []ModerationAction
+
+	bans   map[string]Ban
+	roles  map[string]UserRole
+	tokens []APIToken
+	audit  []AuditEvent
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:      map[string]User{},
+		signatures: map[string]Signature{},
+		bans:       map[string]Ban{},
+		roles:      map[string]UserRole{},
+	}
+}
+
+func (ms *MemoryStore) newID() uint {
+	ms.nextID++
+	return ms.nextID
+}
+
+func (ms *MemoryStore) UpsertUser(ctx context.Context, u *User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	existing, ok := ms.users[u.UserID]
+	if ok {
+		u.ID = existing.ID
+		u.CreatedAt = existing.CreatedAt
+	} else {
+		u.ID = ms.newID()
+		u.CreatedAt = now
+	}
+
+	u.UpdatedAt = now
+	ms.users[u.UserID] = *u
+	return nil
+}
+
+func (ms *MemoryStore) GetUser(ctx context.Context, userID string) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &u, nil
+}
+
+// liveSignature returns the user's signature unless it was removed.
+func (ms *MemoryStore) liveSignature(userID string) (Signature, bool) {
+	sig, ok := ms.signatures[userID]
+	return sig, ok && !sig.DeletedAt.Valid
+}
+
+// shiftPositions moves every live signature at or after from by delta.
+func (ms *MemoryStore) shiftPositions(from int64, delta int64) {
+	for id, s := range ms.signatures {
+		if s.Position != nil && *s.Position >= from {
+			pos := *s.Position + delta
+			s.Position = &pos
+			ms.signatures[id] = s
+		}
+	}
+}
+
+func (ms *MemoryStore) countLive() int64 {
+	var count int64
+	for _, s := range ms.signatures {
+		if !s.DeletedAt.Valid {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (ms *MemoryStore) CreateSignature(ctx context.Context, sig *Signature) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if existing, ok := ms.signatures[sig.UserID]; ok {
+		if existing.DeletedAt.Valid {
+			return ErrSignatureRemoved
+		}
+
+		return ErrAlreadySigned
+	}
+
+	if sig.ReferrerID != nil {
+		if _, ok := ms.liveSignature(*sig.ReferrerID); !ok {
+			sig.ReferrerID = nil
+		}
+	}
+
+	now := time.Now()
+	pos := ms.countLive() + 1
+
+	sig.ID = ms.newID()
+	sig.CreatedAt = now
+	sig.UpdatedAt = now
+	sig.Position = &pos
+
+	ms.signatures[sig.UserID] = *sig
+	return nil
+}
+
+func (ms *MemoryStore) DeleteSignature(ctx context.Context, userID string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sig, ok := ms.signatures[userID]
+	if !ok {
+		return false, nil
+	}
+
+	if sig.DeletedAt.Valid {
+		return false, ErrSignatureRemoved
+	}
+
+	delete(ms.signatures, userID)
+	ms.shiftPositions(*sig.Position+1, -1)
+
+	return true, nil
+}
+
+func (ms *MemoryStore) GetSignature(ctx context.Context, userID string) (*Signature, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sig, ok := ms.liveSignature(userID)
+	if !ok {
+		return nil, nil
+	}
+
+	return &sig, nil
+}
+
+func (ms *MemoryStore) GetPosition(ctx context.Context, userID string) (int64, error) {
+	sig, _ := ms.GetSignature(ctx, userID)
+	if sig == nil {
+		return 0, nil
+	}
+
+	return *sig.Position, nil
+}
+
+func (ms *MemoryStore) CountReferrals(ctx context.Context, userID string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var count int64
+	for _, s := range ms.signatures {
+		if !s.DeletedAt.Valid && s.ReferrerID != nil && *s.ReferrerID == userID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (ms *MemoryStore) CountSignatures(ctx context.Context) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.countLive(), nil
+}
+
+func (ms *MemoryStore) ListSignatures(ctx context.Context, limit int, offset int) ([]Signature, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sigs := make([]Signature, 0, len(ms.signatures))
+	for _, s := range ms.signatures {
+		if !s.DeletedAt.Valid {
+			sigs = append(sigs, s)
+		}
+	}
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return *sigs[i].Position < *sigs[j].Position
+	})
+
+	start, end := pageBounds(len(sigs), limit, offset)
+	return sigs[start:end], nil
+}
+
+// pageBounds returns the slice bounds of limit items starting at offset out of
+// n, a limit of 0 meaning all.
+func pageBounds(n int, limit int, offset int) (int, int) {
+	if offset >= n {
+		return n, n
+	}
+
+	if limit > 0 && offset+limit < n {
+		return offset, offset + limit
+	}
+
+	return offset, n
+}
+
+func (ms *MemoryStore) GetActiveBan(ctx context.Context, userID string) (*Ban, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ban, ok := ms.bans[userID]
+	if !ok || !ban.Active() {
+		return nil, nil
+	}
+
+	return &ban, nil
+}
+
+func (ms *MemoryStore) BanUser(ctx context.Context, ban *Ban) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := ms.bans[ban.UserID]; ok {
+		ban.ID = existing.ID
+		ban.CreatedAt = existing.CreatedAt
+	} else {
+		ban.ID = ms.newID()
+		ban.CreatedAt = now
+	}
+
+	ban.UpdatedAt = now
+	ms.bans[ban.UserID] = *ban
+	return nil
+}
+
+func (ms *MemoryStore) UnbanUser(ctx context.Context, userID string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, ok := ms.bans[userID]
+	delete(ms.bans, userID)
+	return ok, nil
+}
+
+func (ms *MemoryStore) ListBans(ctx context.Context, includeExpired bool) ([]Ban, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	bans := []Ban{}
+	for _, b := range ms.bans {
+		if includeExpired || b.Active() {
+			bans = append(bans, b)
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].CreatedAt.After(bans[j].CreatedAt)
+	})
+
+	return bans, nil
+}
+
+func (ms *MemoryStore) GetUserRole(ctx context.Context, userID string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.roles[userID].Role, nil
+}
+
+func (ms *MemoryStore) SetUserRole(ctx context.Context, role *UserRole) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := ms.roles[role.UserID]; ok {
+		role.ID = existing.ID
+		role.CreatedAt = existing.CreatedAt
+	} else {
+		role.ID = ms.newID()
+		role.CreatedAt = now
+	}
+
+	role.UpdatedAt = now
+	ms.roles[role.UserID] = *role
+	return nil
+}
+
+func (ms *MemoryStore) RemoveUserRole(ctx context.Context, userID string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, ok := ms.roles[userID]
+	delete(ms.roles, userID)
+	return ok, nil
+}
+
+func (ms *MemoryStore) ListUserRoles(ctx context.Context) ([]UserRole, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	roles := make([]UserRole, 0, len(ms.roles))
+	for _, r := range ms.roles {
+		roles = append(roles, r)
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].UserID < roles[j].UserID
+	})
+
+	return roles, nil
+}
+
+func (ms *MemoryStore) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	t.ID = ms.newID()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+
+	ms.tokens = append(ms.tokens, *t)
+	return nil
+}
+
+func (ms *MemoryStore) ListAPITokens(ctx context.Context, userID string) ([]APIToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tokens := []APIToken{}
+	for i := len(ms.tokens) - 1; i >= 0; i-- {
+		if ms.tokens[i].UserID == userID {
+			tokens = append(tokens, ms.tokens[i])
+		}
+	}
+
+	return tokens, nil
+}
+
+func (ms *MemoryStore) RevokeAPIToken(ctx context.Context, userID string, id uint) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i, t := range ms.tokens {
+		if t.ID == id && t.UserID == userID {
+			ms.tokens = append(ms.tokens[:i], ms.tokens[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (ms *MemoryStore) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, t := range ms.tokens {
+		if t.TokenHash == hash {
+			return &t, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (ms *MemoryStore) TouchAPIToken(ctx context.Context, id uint, usedAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.tokens {
+		if ms.tokens[i].ID == id {
+			ms.tokens[i].LastUsedAt = &usedAt
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) RecordAuditEvent(ctx context.Context, e *AuditEvent) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	e.ID = uint64(ms.newID())
+	e.CreatedAt = time.Now()
+
+	ms.audit = append(ms.audit, *e)
+	return nil
+}
+
+func (ms *MemoryStore) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	events := []AuditEvent{}
+	for i := len(ms.audit) - 1; i >= 0; i-- {
+		e := ms.audit[i]
+
+		switch {
+		case f.ActorID != "" && (e.ActorID == nil || *e.ActorID != f.ActorID),
+			f.Action != "" && e.Action != f.Action,
+			f.Target != "" && e.Target != f.Target,
+			f.From != nil && e.CreatedAt.Before(*f.From),
+			f.To != nil && !e.CreatedAt.Before(*f.To),
+			f.Before != 0 && e.ID >= f.Before:
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	start, end := pageBounds(len(events), f.Limit, 0)
+	return events[start:end], nil
+}
+
+func (ms *MemoryStore) SearchSignatures(ctx context.Context, f SignatureFilter) ([]SignatureResult, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sigs := make([]Signature, 0, len(ms.signatures))
+	for _, s := range ms.signatures {
+		sigs = append(sigs, s)
+	}
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return signedBefore(sigs[i], sigs[j])
+	})
+
+	results := []SignatureResult{}
+	for _, s := range sigs {
+		removed := s.DeletedAt.Valid
+		user := ms.users[s.UserID]
+
+		switch {
+		case f.OnlyRemoved && !removed,
+			!f.OnlyRemoved && !f.IncludeRemoved && removed,
+			f.UserID != "" && s.UserID != f.UserID,
+			f.Username != "" && !strings.Contains(strings.ToLower(user.Username), strings.ToLower(f.Username)),
+			f.ReferrerID != "" && (s.ReferrerID == nil || *s.ReferrerID != f.ReferrerID),
+			f.From != nil && s.CreatedAt.Before(*f.From),
+			f.To != nil && !s.CreatedAt.Before(*f.To):
+			continue
+		}
+
+		r := SignatureResult{
+			ID:            s.ID,
+			UserID:        s.UserID,
+			Username:      user.Username,
+			Discriminator: user.Discriminator,
+			ReferrerID:    s.ReferrerID,
+			CreatedAt:     s.CreatedAt,
+		}
+
+		if removed {
+			removedAt := s.DeletedAt.Time
+			r.RemovedAt = &removedAt
+		}
+
+		results = append(results, r)
+	}
+
+	start, end := pageBounds(len(results), f.Limit, f.Offset)
+	return results[start:end], nil
+}
+
+// signedBefore orders signatures like reclaimPosition does.
+func signedBefore(a Signature, b Signature) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID < b.ID
+	}
+
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+func (ms *MemoryStore) RemoveSignature(ctx context.Context, userID string, actorID string, reason string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sig, ok := ms.liveSignature(userID)
+	if !ok {
+		return ErrSignatureNotFound
+	}
+
+	pos := *sig.Position
+	sig.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	sig.Position = nil
+	ms.signatures[userID] = sig
+	ms.shiftPositions(pos+1, -1)
+
+	ms.recordModeration(sig, MODERATION_ACTION_REMOVE, actorID, reason)
+	return nil
+}
+
+func (ms *MemoryStore) RestoreSignature(ctx context.Context, userID string, actorID string, reason string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sig, ok := ms.signatures[userID]
+	if !ok || !sig.DeletedAt.Valid {
+		return ErrSignatureNotFound
+	}
+
+	var before int64
+	for _, s := range ms.signatures {
+		if !s.DeletedAt.Valid && signedBefore(s, sig) {
+			before++
+		}
+	}
+
+	pos := before + 1
+	ms.shiftPositions(pos, 1)
+
+	sig.DeletedAt = gorm.DeletedAt{}
+	sig.Position = &pos
+	ms.signatures[userID] = sig
+
+	ms.recordModeration(sig, MODERATION_ACTION_RESTORE, actorID, reason)
+	return nil
+}
+
+func (ms *MemoryStore) recordModeration(sig Signature, action string, actorID string, reason string) {
+	ms.actions = append(ms.actions, ModerationAction{
+		ID:          ms.newID(),
+		CreatedAt:   time.Now(),
+		SignatureID: sig.ID,
+		UserID:      sig.UserID,
+		Action:      action,
+		Reason:      reason,
+		ActorID:     actorID,
+	})
+}
+
+func (ms *MemoryStore) ListModerationActions(ctx context.Context, userID string) ([]ModerationAction, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	actions := []ModerationAction{}
+	for i := len(ms.actions) - 1; i >= 0; i-- {
+		if ms.actions[i].UserID == userID {
+			actions = append(actions, ms.actions[i])
+		}
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"github.com/thankyoudiscord/api/pkg/logging"
+)
+
+// Migrations live in migrations/ as <version>_<name>.up.sql and
+// <version>_<name>.down.sql and are embedded into the binary. Applied versions
+// are recorded in schema_migrations.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// arbitrary key for the advisory lock that keeps replicas from migrating at
+// the same time
+const MIGRATION_LOCK_KEY = 7245106
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations returns the embedded migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %v", e.Name())
+		}
+
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+
+		b, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %v has two names: %v and %v", version, mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %v_%v needs both an up and a down file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrationStatus returns the current schema version and the migrations that
+// haven't been applied yet.
+func MigrationStatus(db *gorm.DB) (int64, []Migration, error) {
+	var version int64
+	var pending []Migration
+
+	err := withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		var err error
+		version, err = currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		migrations, err := LoadMigrations()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.Version > version {
+				pending = append(pending, m)
+			}
+		}
+
+		return nil
+	})
+
+	return version, pending, err
+}
+
+// MigrateUp applies every pending migration, each in its own transaction.
+// Applied migrations are logged to the logger of db's context.
+func MigrateUp(db *gorm.DB) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		version, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+					return err
+				}
+
+				_, err := tx.ExecContext(
+					ctx,
+					"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+					m.Version,
+					m.Name,
+				)
+				return err
+			})
+
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %v_%v: %w", m.Version, m.Name, err)
+			}
+
+			logging.Ctx(ctx).Info().Int64("version", m.Version).Str("name", m.Name).Msg("applied migration")
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown reverts the last steps applied migrations, logging them like
+// MigrateUp.
+func MigrateDown(db *gorm.DB, steps int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		for i := 0; i < steps; i++ {
+			version, err := currentVersion(ctx, conn)
+			if err != nil {
+				return err
+			}
+
+			if version == 0 {
+				return nil
+			}
+
+			var m *Migration
+			for j := range migrations {
+				if migrations[j].Version == version {
+					m = &migrations[j]
+				}
+			}
+
+			if m == nil {
+				return fmt.Errorf("no migration found for applied version %v", version)
+			}
+
+			err = inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+					return err
+				}
+
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return err
+			})
+
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %v_%v: %w", m.Version, m.Name, err)
+			}
+
+			logging.Ctx(ctx).Info().Int64("version", m.Version).Str("name", m.Name).Msg("reverted migration")
+		}
+
+		return nil
+	})
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, creating schema_migrations if needed.
+func withMigrationLock(db *gorm.DB, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := db.Statement.Context
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	// advisory locks belong to a session, so everything has to happen on the
+	// same connection
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", MIGRATION_LOCK_KEY); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", MIGRATION_LOCK_KEY)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var version int64
+	row := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	err := row.Scan(&version)
+	return version, err
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
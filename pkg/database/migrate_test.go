@@ -0,0 +1,89 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %v_%v is out of sequence, want version %v", m.Version, m.Name, i+1)
+		}
+	}
+}
+
+// Migration 0004 ranks existing signatures into positions and seeds the
+// counter from them.
+func TestSignaturePositionsMigrationBackfills(t *testing.T) {
+	db := testDB(t)
+
+	version, _, err := MigrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateDown(db, int(version-3)); err != nil {
+		t.Fatalf("failed to migrate down to 0003: %v", err)
+	}
+
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := []struct {
+		userID    string
+		createdAt time.Time
+		removed   bool
+	}{
+		// inserted out of order, ranked by created_at then id
+		{"c", base.Add(2 * time.Hour), false},
+		{"a", base, false},
+		{"removed", base.Add(30 * time.Minute), true},
+		{"b", base.Add(time.Hour), false},
+		{"b2", base.Add(time.Hour), false},
+	}
+
+	for _, r := range rows {
+		err := db.Exec(
+			"INSERT INTO users (user_id, username, discriminator) VALUES (?, ?, '0001')",
+			r.userID, r.userID,
+		).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var deletedAt *time.Time
+		if r.removed {
+			deletedAt = &r.createdAt
+		}
+
+		err = db.Exec(
+			"INSERT INTO signatures (user_id, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?)",
+			r.userID, r.createdAt, r.createdAt, deletedAt,
+		).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+
+	assertPositions(t, db, map[string]int64{
+		"a":       1,
+		"removed": 0,
+		"b":       2,
+		"b2":      3,
+		"c":       4,
+	})
+
+	// new signatures continue from the seeded counter
+	createSigners(t, db, "d")
+	if pos := positions(t, db)["d"]; pos != 5 {
+		t.Errorf("position of d = %v, want 5", pos)
+	}
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS bans;
+DROP TABLE IF EXISTS moderation_actions;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS signatures;
+DROP TABLE IF EXISTS users;
+
+-- vim:et ts=2 sw=2
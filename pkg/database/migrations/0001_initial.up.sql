@@ -0,0 +1,94 @@
+-- The schema gorm's AutoMigrate used to create. Everything is IF NOT EXISTS so
+-- databases that were set up by AutoMigrate can be brought under migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  updated_at timestamptz,
+  deleted_at timestamptz,
+  user_id text NOT NULL,
+  username text NOT NULL,
+  discriminator text NOT NULL,
+  avatar_hash text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_user_id ON users (user_id);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS signatures (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  updated_at timestamptz,
+  deleted_at timestamptz,
+  user_id text NOT NULL,
+  referrer_id text,
+  CONSTRAINT fk_users_signature FOREIGN KEY (user_id) REFERENCES users (user_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signatures_user_id ON signatures (user_id);
+CREATE INDEX IF NOT EXISTS idx_signatures_deleted_at ON signatures (deleted_at);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  updated_at timestamptz,
+  deleted_at timestamptz,
+  user_id text NOT NULL,
+  name text NOT NULL,
+  token_hash text NOT NULL,
+  scopes text NOT NULL,
+  last_used_at timestamptz,
+  expires_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_deleted_at ON api_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  updated_at timestamptz,
+  deleted_at timestamptz,
+  user_id text NOT NULL,
+  role text NOT NULL,
+  granted_by text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_roles_user_id ON user_roles (user_id);
+CREATE INDEX IF NOT EXISTS idx_user_roles_deleted_at ON user_roles (deleted_at);
+
+CREATE TABLE IF NOT EXISTS moderation_actions (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  signature_id bigint NOT NULL,
+  user_id text NOT NULL,
+  action text NOT NULL,
+  reason text NOT NULL,
+  actor_id text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_signature_id ON moderation_actions (signature_id);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_user_id ON moderation_actions (user_id);
+
+CREATE TABLE IF NOT EXISTS bans (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  updated_at timestamptz,
+  user_id text NOT NULL,
+  reason text NOT NULL,
+  expires_at timestamptz,
+  banned_by text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bans_user_id ON bans (user_id);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+  id bigserial PRIMARY KEY,
+  created_at timestamptz,
+  actor_id text,
+  action text NOT NULL,
+  target text,
+  ip text,
+  metadata jsonb
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target);
+
+-- vim:et ts=2 sw=2
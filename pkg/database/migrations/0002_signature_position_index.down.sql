@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_signatures_live_created_at;
+
+-- vim:et ts=2 sw=2
@@ -0,0 +1,6 @@
+-- Positions are ranked by created_at over live signatures.
+CREATE INDEX IF NOT EXISTS idx_signatures_live_created_at
+ON signatures (created_at)
+WHERE deleted_at IS NULL;
+
+-- vim:et ts=2 sw=2
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS signature_position_counter;
+DROP INDEX IF EXISTS idx_signatures_position;
+ALTER TABLE signatures DROP COLUMN IF EXISTS position;
+
+-- vim:et ts=2 sw=2
@@ -0,0 +1,31 @@
+-- Positions are stored on insert instead of ranked on every read. The counter
+-- row is locked by every write that changes positions, which serializes them.
+
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS position bigint;
+
+UPDATE signatures
+SET position = ranked.position
+FROM (
+  SELECT
+    id,
+    ROW_NUMBER() OVER (
+      ORDER BY created_at ASC, id ASC
+    ) AS position
+  FROM signatures
+  WHERE deleted_at IS NULL
+) AS ranked
+WHERE signatures.id = ranked.id;
+
+CREATE INDEX IF NOT EXISTS idx_signatures_position ON signatures (position);
+
+CREATE TABLE IF NOT EXISTS signature_position_counter (
+  id int PRIMARY KEY CHECK (id = 1),
+  last_position bigint NOT NULL
+);
+
+INSERT INTO signature_position_counter (id, last_position)
+SELECT 1, COUNT(*)
+FROM signatures
+WHERE deleted_at IS NULL;
+
+-- vim:et ts=2 sw=2
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MODERATION_ACTION_REMOVE  = "remove"
+	MODERATION_ACTION_RESTORE = "restore"
+)
+
+var ErrSignatureNotFound = errors.New("signature not found")
+
+// ModerationAction records a moderator removing or restoring a signature.
+type ModerationAction struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	SignatureID uint   `json:"signature_id" gorm:"index;not null"`
+	UserID      string `json:"user_id" gorm:"index;not null"`
+	Action      string `json:"action" gorm:"not null"`
+	Reason      string `json:"reason" gorm:"not null"`
+	ActorID     string `json:"actor_id" gorm:"not null"`
+}
+
+// SignatureFilter narrows down SearchSignatures. Zero values don't filter.
+type SignatureFilter struct {
+	UserID     string
+	Username   string
+	ReferrerID string
+	From       *time.Time
+	To         *time.Time
+
+	// include removed signatures, or only return removed ones
+	IncludeRemoved bool
+	OnlyRemoved    bool
+
+	Limit  int
+	Offset int
+}
+
+type SignatureResult struct {
+	ID            uint       `json:"id"`
+	UserID        string     `json:"user_id"`
+	Username      string     `json:"username"`
+	Discriminator string     `json:"discriminator"`
+	ReferrerID    *string    `json:"referrer_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RemovedAt     *time.Time `json:"removed_at"`
+}
+
+func SearchSignatures(db *gorm.DB, f SignatureFilter) ([]SignatureResult, error) {
+	q := db.Table("signatures").
+		Select(`
+			signatures.id,
+			signatures.user_id,
+			users.username,
+			users.discriminator,
+			signatures.referrer_id,
+			signatures.created_at,
+			signatures.deleted_at AS removed_at
+		`).
+		Joins("LEFT JOIN users ON users.user_id = signatures.user_id")
+
+	if f.OnlyRemoved {
+		q = q.Where("signatures.deleted_at IS NOT NULL")
+	} else if !f.IncludeRemoved {
+		q = q.Where("signatures.deleted_at IS NULL")
+	}
+
+	if f.UserID != "" {
+		q = q.Where("signatures.user_id = ?", f.UserID)
+	}
+
+	if f.Username != "" {
+		q = q.Where("users.username ILIKE ?", "%"+f.Username+"%")
+	}
+
+	if f.ReferrerID != "" {
+		q = q.Where("signatures.referrer_id = ?", f.ReferrerID)
+	}
+
+	if f.From != nil {
+		q = q.Where("signatures.created_at >= ?", *f.From)
+	}
+
+	if f.To != nil {
+		q = q.Where("signatures.created_at < ?", *f.To)
+	}
+
+	results := []SignatureResult{}
+	res := q.
+		Order("signatures.created_at ASC").
+		Limit(f.Limit).
+		Offset(f.Offset).
+		Scan(&results)
+
+	return results, res.Error
+}
+
+// RemoveSignature soft deletes a user's signature, which hides it from
+// positions, stats and the banner, and records who did it and why. Everyone
+// who signed after them moves up a place.
+func RemoveSignature(db *gorm.DB, userID string, actorID string, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPositions(tx); err != nil {
+			return err
+		}
+
+		var sig Signature
+		res := tx.Where("user_id = ?", userID).First(&sig)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrSignatureNotFound
+		}
+
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if err := tx.Delete(&sig).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&sig).Update("position", nil).Error; err != nil {
+			return err
+		}
+
+		if err := releasePosition(tx, sig.Position); err != nil {
+			return err
+		}
+
+		return tx.Create(&ModerationAction{
+			SignatureID: sig.ID,
+			UserID:      userID,
+			Action:      MODERATION_ACTION_REMOVE,
+			Reason:      reason,
+			ActorID:     actorID,
+		}).Error
+	})
+}
+
+// RestoreSignature undoes RemoveSignature, putting the signature back at its
+// original place in the signing order.
+func RestoreSignature(db *gorm.DB, userID string, actorID string, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPositions(tx); err != nil {
+			return err
+		}
+
+		var sig Signature
+		res := tx.Unscoped().
+			Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+			First(&sig)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrSignatureNotFound
+		}
+
+		if res.Error != nil {
+			return res.Error
+		}
+
+		err := tx.Unscoped().
+			Model(&sig).
+			Update("deleted_at", nil).
+			Error
+		if err != nil {
+			return err
+		}
+
+		if err := reclaimPosition(tx, &sig); err != nil {
+			return err
+		}
+
+		return tx.Create(&ModerationAction{
+			SignatureID: sig.ID,
+			UserID:      userID,
+			Action:      MODERATION_ACTION_RESTORE,
+			Reason:      reason,
+			ActorID:     actorID,
+		}).Error
+	})
+}
+
+func ListModerationActions(db *gorm.DB, userID string) ([]ModerationAction, error) {
+	actions := []ModerationAction{}
+	res := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&actions)
+	return actions, res.Error
+}
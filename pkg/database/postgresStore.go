@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore implements Store on top of gorm.
+type PostgresStore struct {
+	DB *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) PostgresStore {
+	return PostgresStore{
+		DB: db,
+	}
+}
+
+func (ps PostgresStore) UpsertUser(ctx context.Context, u *User) error {
+	res := ps.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"username", "discriminator", "avatar_hash", "updated_at"}),
+	}).Create(u)
+
+	return res.Error
+}
+
+func (ps PostgresStore) GetUser(ctx context.Context, userID string) (*User, error) {
+	var u User
+	res := ps.DB.WithContext(ctx).Where("user_id = ?", userID).Limit(1).Find(&u)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+
+	return &u, nil
+}
+
+func (ps PostgresStore) CreateSignature(ctx context.Context, sig *Signature) error {
+	return CreateSignature(ps.DB.WithContext(ctx), sig)
+}
+
+func (ps PostgresStore) DeleteSignature(ctx context.Context, userID string) (bool, error) {
+	return DeleteSignature(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) GetSignature(ctx context.Context, userID string) (*Signature, error) {
+	var sig Signature
+	res := ps.DB.WithContext(ctx).Where("user_id = ?", userID).Limit(1).Find(&sig)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+
+	return &sig, nil
+}
+
+func (ps PostgresStore) GetPosition(ctx context.Context, userID string) (int64, error) {
+	return GetUserPosition(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) CountReferrals(ctx context.Context, userID string) (int64, error) {
+	var count int64
+	res := ps.DB.WithContext(ctx).Model(&Signature{}).Where("referrer_id = ?", userID).Count(&count)
+	return count, res.Error
+}
+
+func (ps PostgresStore) CountSignatures(ctx context.Context) (int64, error) {
+	var count int64
+	res := ps.DB.WithContext(ctx).Model(&Signature{}).Count(&count)
+	return count, res.Error
+}
+
+func (ps PostgresStore) ListSignatures(ctx context.Context, limit int, offset int) ([]Signature, error) {
+	sigs := []Signature{}
+	res := ps.DB.WithContext(ctx).Order("position ASC").Limit(limit).Offset(offset).Find(&sigs)
+	return sigs, res.Error
+}
+
+func (ps PostgresStore) GetActiveBan(ctx context.Context, userID string) (*Ban, error) {
+	return GetActiveBan(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) BanUser(ctx context.Context, ban *Ban) error {
+	return BanUser(ps.DB.WithContext(ctx), ban)
+}
+
+func (ps PostgresStore) UnbanUser(ctx context.Context, userID string) (bool, error) {
+	return UnbanUser(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) ListBans(ctx context.Context, includeExpired bool) ([]Ban, error) {
+	return ListBans(ps.DB.WithContext(ctx), includeExpired)
+}
+
+func (ps PostgresStore) GetUserRole(ctx context.Context, userID string) (string, error) {
+	return GetUserRole(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) SetUserRole(ctx context.Context, role *UserRole) error {
+	return SetUserRole(ps.DB.WithContext(ctx), role)
+}
+
+func (ps PostgresStore) RemoveUserRole(ctx context.Context, userID string) (bool, error) {
+	return RemoveUserRole(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) ListUserRoles(ctx context.Context) ([]UserRole, error) {
+	return ListUserRoles(ps.DB.WithContext(ctx))
+}
+
+func (ps PostgresStore) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	return CreateAPIToken(ps.DB.WithContext(ctx), t)
+}
+
+func (ps PostgresStore) ListAPITokens(ctx context.Context, userID string) ([]APIToken, error) {
+	return ListAPITokens(ps.DB.WithContext(ctx), userID)
+}
+
+func (ps PostgresStore) RevokeAPIToken(ctx context.Context, userID string, id uint) (bool, error) {
+	return RevokeAPIToken(ps.DB.WithContext(ctx), userID, id)
+}
+
+func (ps PostgresStore) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	return GetAPITokenByHash(ps.DB.WithContext(ctx), hash)
+}
+
+func (ps PostgresStore) TouchAPIToken(ctx context.Context, id uint, usedAt time.Time) error {
+	return TouchAPIToken(ps.DB.WithContext(ctx), id, usedAt)
+}
+
+func (ps PostgresStore) RecordAuditEvent(ctx context.Context, e *AuditEvent) error {
+	return RecordAuditEvent(ps.DB.WithContext(ctx), e)
+}
+
+func (ps PostgresStore) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	return ListAuditEvents(ps.DB.WithContext(ctx), f)
+}
+
+func (ps PostgresStore) SearchSignatures(ctx context.Context, f SignatureFilter) ([]SignatureResult, error) {
+	return SearchSignatures(ps.DB.WithContext(ctx), f)
+}
+
+func (ps PostgresStore) RemoveSignature(ctx context.Context, userID string, actorID string, reason string) error {
+	return RemoveSignature(ps.DB.WithContext(ctx), userID, actorID, reason)
+}
+
+func (ps PostgresStore) RestoreSignature(ctx context.Context, userID string, actorID string, reason string) error {
+	return RestoreSignature(ps.DB.WithContext(ctx), userID, actorID, reason)
+}
+
+func (ps PostgresStore) ListModerationActions(ctx context.Context, userID string) ([]ModerationAction, error) {
+	return ListModerationActions(ps.DB.WithContext(ctx), userID)
+}
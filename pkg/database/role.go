@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserRole grants a user access to the admin API.
+type UserRole struct {
+	gorm.Model `json:"-"`
+
+	UserID    string  `json:"user_id" gorm:"uniqueIndex;not null"`
+	Role      string  `json:"role" gorm:"not null"`
+	GrantedBy *string `json:"granted_by"`
+}
+
+// GetUserRole returns the user's role, or an empty string if they have none.
+func GetUserRole(db *gorm.DB, userID string) (string, error) {
+	var role UserRole
+	res := db.Where("user_id = ?", userID).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+
+		return "", res.Error
+	}
+
+	return role.Role, nil
+}
+
+// SetUserRole grants a role, replacing any role the user had.
+func SetUserRole(db *gorm.DB, role *UserRole) error {
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "granted_by", "updated_at"}),
+	}).Create(role)
+
+	return res.Error
+}
+
+// RemoveUserRole takes away a user's role. It returns false if they had none.
+func RemoveUserRole(db *gorm.DB, userID string) (bool, error) {
+	res := db.Where("user_id = ?", userID).
+		Unscoped().
+		Delete(&UserRole{})
+
+	return res.RowsAffected != 0, res.Error
+}
+
+func ListUserRoles(db *gorm.DB) ([]UserRole, error) {
+	roles := []UserRole{}
+	res := db.Order("user_id").Find(&roles)
+	return roles, res.Error
+}
@@ -1,12 +1,202 @@
 package database
 
 import (
+	"errors"
+
+	"github.com/jackc/pgconn"
 	"gorm.io/gorm"
 )
 
+// ErrAlreadySigned is returned when a user with a live signature signs again.
+var ErrAlreadySigned = errors.New("user has already signed")
+
+// ErrSignatureRemoved is returned when a user whose signature was removed by a
+// moderator tries to sign or unsign. Only RestoreSignature brings it back.
+var ErrSignatureRemoved = errors.New("signature was removed by a moderator")
+
 type Signature struct {
 	gorm.Model `json:"-"`
 
 	UserID     string  `json:"user_id" gorm:"uniqueIndex;not null"`
 	ReferrerID *string `json:"referrer_id"`
+
+	// 1-based place in the signing order among live signatures, nil once the
+	// signature is removed
+	Position *int64 `json:"position" gorm:"index"`
+}
+
+// lockPositions locks the position counter row until tx ends, which serializes
+// all position changes. Every transaction that changes positions must call it
+// before touching any signature row, so they all take their locks in the same
+// order and can't deadlock.
+func lockPositions(tx *gorm.DB) error {
+	return tx.Exec(`
+		SELECT last_position
+		FROM signature_position_counter
+		WHERE id = 1
+		FOR UPDATE
+	`).Error
+}
+
+// nextPosition takes the next position from the counter. The caller must hold
+// lockPositions.
+func nextPosition(tx *gorm.DB) (int64, error) {
+	var pos int64
+	res := tx.Raw(`
+		UPDATE signature_position_counter
+		SET last_position = last_position + 1
+		WHERE id = 1
+		RETURNING last_position
+	`).Scan(&pos)
+
+	return pos, res.Error
+}
+
+// CreateSignature inserts a signature and assigns it the next position, all in
+// one transaction. The referrer is dropped unless they have a live signature.
+// On success sig holds the committed position. Fails with ErrAlreadySigned if
+// the user already signed, or ErrSignatureRemoved if a moderator removed the
+// user's signature.
+func CreateSignature(db *gorm.DB, sig *Signature) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// the counter lock also keeps the referrer from unsigning until we
+		// commit, every transaction that removes signatures takes it first
+		if err := lockPositions(tx); err != nil {
+			return err
+		}
+
+		var existing Signature
+		res := tx.Unscoped().Select("deleted_at").Where("user_id = ?", sig.UserID).Limit(1).Find(&existing)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if existing.DeletedAt.Valid {
+			return ErrSignatureRemoved
+		}
+
+		if res.RowsAffected > 0 {
+			return ErrAlreadySigned
+		}
+
+		if sig.ReferrerID != nil {
+			var ref Signature
+			res := tx.Select("id").
+				Where("user_id = ?", *sig.ReferrerID).
+				Limit(1).
+				Find(&ref)
+			if res.Error != nil {
+				return res.Error
+			}
+
+			if res.RowsAffected == 0 {
+				sig.ReferrerID = nil
+			}
+		}
+
+		pos, err := nextPosition(tx)
+		if err != nil {
+			return err
+		}
+
+		sig.Position = &pos
+		return tx.Create(sig).Error
+	})
+
+	// the unique index on user_id, in case the row showed up anyway
+	var e *pgconn.PgError
+	if errors.As(err, &e) && e.Code == "23505" {
+		return ErrAlreadySigned
+	}
+
+	return err
+}
+
+// DeleteSignature hard deletes a user's signature and moves everyone after it
+// up a place. It returns false if the user hadn't signed, and
+// ErrSignatureRemoved if a moderator removed their signature, which is kept so
+// it can be restored.
+func DeleteSignature(db *gorm.DB, userID string) (bool, error) {
+	found := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPositions(tx); err != nil {
+			return err
+		}
+
+		var sig Signature
+		res := tx.Unscoped().Where("user_id = ?", userID).Limit(1).Find(&sig)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		if sig.DeletedAt.Valid {
+			return ErrSignatureRemoved
+		}
+
+		found = true
+
+		if err := tx.Unscoped().Delete(&sig).Error; err != nil {
+			return err
+		}
+
+		return releasePosition(tx, sig.Position)
+	})
+
+	return found, err
+}
+
+// releasePosition closes the gap a removed signature leaves behind. The caller
+// must hold lockPositions.
+func releasePosition(tx *gorm.DB, pos *int64) error {
+	// removed signatures don't hold a position
+	if pos == nil {
+		return nil
+	}
+
+	res := tx.Exec(`
+		UPDATE signature_position_counter
+		SET last_position = last_position - 1
+		WHERE id = 1
+	`)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return tx.Exec(`
+		UPDATE signatures
+		SET position = position - 1
+		WHERE position > ? AND deleted_at IS NULL
+	`, *pos).Error
+}
+
+// reclaimPosition puts a restored signature back where it was in the signing
+// order and moves everyone after it down a place. The caller must hold
+// lockPositions.
+func reclaimPosition(tx *gorm.DB, sig *Signature) error {
+	if _, err := nextPosition(tx); err != nil {
+		return err
+	}
+
+	var before int64
+	res := tx.Model(&Signature{}).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", sig.CreatedAt, sig.CreatedAt, sig.ID).
+		Count(&before)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	pos := before + 1
+
+	res = tx.Exec(`
+		UPDATE signatures
+		SET position = position + 1
+		WHERE position >= ? AND deleted_at IS NULL
+	`, pos)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	sig.Position = &pos
+	return tx.Unscoped().Model(sig).Update("position", pos).Error
 }
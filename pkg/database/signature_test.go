@@ -0,0 +1,268 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCreateSignatureAssignsPositions(t *testing.T) {
+	db := testDB(t)
+
+	createSigners(t, db, "a", "b", "c")
+
+	assertPositions(t, db, map[string]int64{"a": 1, "b": 2, "c": 3})
+}
+
+func TestDeleteSignatureCompactsPositions(t *testing.T) {
+	db := testDB(t)
+
+	createSigners(t, db, "a", "b", "c", "d")
+
+	found, err := DeleteSignature(db, "b")
+	if err != nil || !found {
+		t.Fatalf("DeleteSignature(b) = %v, %v", found, err)
+	}
+
+	assertPositions(t, db, map[string]int64{"a": 1, "c": 2, "d": 3})
+
+	found, err = DeleteSignature(db, "b")
+	if err != nil || found {
+		t.Fatalf("second DeleteSignature(b) = %v, %v, want false, nil", found, err)
+	}
+
+	// the next signer takes the freed up last place
+	createSigners(t, db, "e")
+	assertPositions(t, db, map[string]int64{"a": 1, "c": 2, "d": 3, "e": 4})
+}
+
+func TestRemoveAndRestoreSignature(t *testing.T) {
+	db := testDB(t)
+
+	createSigners(t, db, "a", "b", "c")
+
+	if err := RemoveSignature(db, "b", "mod", "spam"); err != nil {
+		t.Fatalf("RemoveSignature(b): %v", err)
+	}
+
+	assertPositions(t, db, map[string]int64{"a": 1, "b": 0, "c": 2})
+
+	if err := RemoveSignature(db, "b", "mod", "spam"); err != ErrSignatureNotFound {
+		t.Fatalf("removing b twice: got %v, want ErrSignatureNotFound", err)
+	}
+
+	createSigners(t, db, "d")
+	assertPositions(t, db, map[string]int64{"a": 1, "b": 0, "c": 2, "d": 3})
+
+	// b goes back to where they originally signed, ahead of c and d
+	if err := RestoreSignature(db, "b", "mod", "appealed"); err != nil {
+		t.Fatalf("RestoreSignature(b): %v", err)
+	}
+
+	assertPositions(t, db, map[string]int64{"a": 1, "b": 2, "c": 3, "d": 4})
+
+	if err := RestoreSignature(db, "b", "mod", "appealed"); err != ErrSignatureNotFound {
+		t.Fatalf("restoring b twice: got %v, want ErrSignatureNotFound", err)
+	}
+
+	actions, err := ListModerationActions(db, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 2 {
+		t.Fatalf("got %v moderation actions, want 2", len(actions))
+	}
+}
+
+// Concurrent removals used to lock their own signature before the counter and
+// could deadlock on each other's rows when compacting.
+func TestConcurrentRemovalsCompactPositions(t *testing.T) {
+	db := testDB(t)
+
+	var ids []string
+	for i := 0; i < 20; i++ {
+		ids = append(ids, fmt.Sprintf("user%02d", i))
+	}
+
+	createSigners(t, db, ids...)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+	for i, id := range ids {
+		if i%2 == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+
+			if i%4 == 1 {
+				_, err := DeleteSignature(db, id)
+				errs <- err
+			} else {
+				errs <- RemoveSignature(db, id, "mod", "spam")
+			}
+		}(i, id)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent removal failed: %v", err)
+		}
+	}
+
+	want := map[string]int64{}
+	for i, id := range ids {
+		switch {
+		case i%2 == 0:
+			want[id] = int64(i/2 + 1)
+		case i%4 == 3:
+			// removed by a moderator, still listed without a position
+			want[id] = 0
+		}
+	}
+
+	assertPositions(t, db, want)
+}
+
+func TestCreateSignatureDropsMissingReferrer(t *testing.T) {
+	db := testDB(t)
+
+	createSigners(t, db, "a")
+
+	for _, id := range []string{"b", "c"} {
+		if err := db.Create(&User{UserID: id, Username: id, Discriminator: "0001"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ref := "a"
+	b := Signature{UserID: "b", ReferrerID: &ref}
+	if err := CreateSignature(db, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.ReferrerID == nil || *b.ReferrerID != "a" {
+		t.Errorf("referrer of b = %v, want a", b.ReferrerID)
+	}
+
+	missing := "nobody"
+	c := Signature{UserID: "c", ReferrerID: &missing}
+	if err := CreateSignature(db, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.ReferrerID != nil {
+		t.Errorf("referrer of c = %v, want nil", *c.ReferrerID)
+	}
+}
+
+func TestCreateSignatureAlreadySigned(t *testing.T) {
+	db := testDB(t)
+
+	createSigners(t, db, "a")
+
+	if err := CreateSignature(db, &Signature{UserID: "a"}); err != ErrAlreadySigned {
+		t.Fatalf("CreateSignature(a) = %v, want ErrAlreadySigned", err)
+	}
+
+	assertPositions(t, db, map[string]int64{"a": 1})
+}
+
+// Signing with a referrer while the referrer unsigns used to lock the two rows
+// and the counter in opposite orders and could deadlock.
+func TestConcurrentSignAndReferrerUnsign(t *testing.T) {
+	db := testDB(t)
+
+	var refs, signers []string
+	for i := 0; i < 10; i++ {
+		refs = append(refs, fmt.Sprintf("ref%02d", i))
+		signers = append(signers, fmt.Sprintf("signer%02d", i))
+	}
+
+	createSigners(t, db, refs...)
+
+	for _, id := range signers {
+		if err := db.Create(&User{UserID: id, Username: id, Discriminator: "0001"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(refs))
+	for i := range refs {
+		wg.Add(2)
+
+		go func(ref string, signer string) {
+			defer wg.Done()
+			errs <- CreateSignature(db, &Signature{UserID: signer, ReferrerID: &ref})
+		}(refs[i], signers[i])
+
+		go func(ref string) {
+			defer wg.Done()
+			_, err := DeleteSignature(db, ref)
+			errs <- err
+		}(refs[i])
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent sign or unsign failed: %v", err)
+		}
+	}
+
+	// only the signers are left, in some order, with contiguous positions
+	got := positions(t, db)
+	seen := map[int64]bool{}
+	for _, id := range signers {
+		pos, ok := got[id]
+		if !ok || pos < 1 || pos > int64(len(signers)) || seen[pos] {
+			t.Fatalf("signer positions aren't 1..%v: %v", len(signers), got)
+		}
+
+		seen[pos] = true
+	}
+
+	if len(got) != len(signers) {
+		t.Errorf("got %v signatures, want %v", len(got), len(signers))
+	}
+
+	if last := lastPosition(t, db); last != int64(len(signers)) {
+		t.Errorf("last_position = %v, want %v", last, len(signers))
+	}
+}
+
+// A moderator's removal must survive the user unsigning and signing again.
+func TestRemovedSignatureCantBeUnsignedOrResigned(t *testing.T) {
+	db := testDB(t)
+
+	createSigners(t, db, "a", "b")
+
+	if err := RemoveSignature(db, "a", "mod", "spam"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DeleteSignature(db, "a"); err != ErrSignatureRemoved {
+		t.Fatalf("DeleteSignature(a) = %v, want ErrSignatureRemoved", err)
+	}
+
+	if err := CreateSignature(db, &Signature{UserID: "a"}); err != ErrSignatureRemoved {
+		t.Fatalf("CreateSignature(a) = %v, want ErrSignatureRemoved", err)
+	}
+
+	assertPositions(t, db, map[string]int64{"a": 0, "b": 1})
+
+	if err := RestoreSignature(db, "a", "mod", "appealed"); err != nil {
+		t.Fatalf("RestoreSignature(a): %v", err)
+	}
+
+	assertPositions(t, db, map[string]int64{"a": 1, "b": 2})
+}
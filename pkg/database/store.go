@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// UserStore keeps the discord profiles of users who logged in.
+type UserStore interface {
+	// UpsertUser creates the user or updates their profile.
+	UpsertUser(ctx context.Context, u *User) error
+
+	// GetUser returns the user, or nil if they never logged in.
+	GetUser(ctx context.Context, userID string) (*User, error)
+}
+
+// SignatureStore keeps the live signatures on the banner.
+type SignatureStore interface {
+	// CreateSignature inserts a signature and assigns it the next position. A
+	// referrer without a live signature is dropped. Fails with
+	// ErrAlreadySigned if the user already signed, or ErrSignatureRemoved if a
+	// moderator removed their signature.
+	CreateSignature(ctx context.Context, sig *Signature) error
+
+	// DeleteSignature removes a user's signature and moves everyone after it up
+	// a place. It returns false if the user hadn't signed, and fails with
+	// ErrSignatureRemoved if a moderator removed it.
+	DeleteSignature(ctx context.Context, userID string) (bool, error)
+
+	// GetSignature returns the user's signature, or nil if they haven't signed.
+	GetSignature(ctx context.Context, userID string) (*Signature, error)
+
+	// GetPosition returns the user's position, or 0 if they haven't signed.
+	GetPosition(ctx context.Context, userID string) (int64, error)
+
+	// CountReferrals returns how many signatures name the user as referrer.
+	CountReferrals(ctx context.Context, userID string) (int64, error)
+
+	CountSignatures(ctx context.Context) (int64, error)
+
+	// ListSignatures returns signatures in signing order.
+	ListSignatures(ctx context.Context, limit int, offset int) ([]Signature, error)
+}
+
+// BanStore keeps the users who aren't allowed to sign.
+type BanStore interface {
+	// GetActiveBan returns the user's ban if they are currently banned, or nil.
+	GetActiveBan(ctx context.Context, userID string) (*Ban, error)
+
+	// BanUser bans a user, replacing any ban they already had.
+	BanUser(ctx context.Context, ban *Ban) error
+
+	// UnbanUser lifts a user's ban. It returns false if they weren't banned.
+	UnbanUser(ctx context.Context, userID string) (bool, error)
+
+	// ListBans returns bans newest first, leaving out expired ones unless
+	// includeExpired is set.
+	ListBans(ctx context.Context, includeExpired bool) ([]Ban, error)
+}
+
+// RoleStore keeps the users who can use the admin API.
+type RoleStore interface {
+	// GetUserRole returns the user's role, or an empty string if they have
+	// none.
+	GetUserRole(ctx context.Context, userID string) (string, error)
+
+	// SetUserRole grants a role, replacing any role the user had.
+	SetUserRole(ctx context.Context, role *UserRole) error
+
+	// RemoveUserRole takes away a user's role. It returns false if they had
+	// none.
+	RemoveUserRole(ctx context.Context, userID string) (bool, error)
+
+	// ListUserRoles returns every granted role ordered by user ID.
+	ListUserRoles(ctx context.Context) ([]UserRole, error)
+}
+
+// APITokenStore keeps the hashes of issued API tokens.
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, t *APIToken) error
+
+	// ListAPITokens returns the user's tokens newest first.
+	ListAPITokens(ctx context.Context, userID string) ([]APIToken, error)
+
+	// RevokeAPIToken deletes one of the user's tokens. It returns false if
+	// the user has no token with that ID.
+	RevokeAPIToken(ctx context.Context, userID string, id uint) (bool, error)
+
+	// GetAPITokenByHash returns the unrevoked token with the hash, or nil.
+	GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error)
+
+	// TouchAPIToken records when the token was last used.
+	TouchAPIToken(ctx context.Context, id uint, usedAt time.Time) error
+}
+
+// AuditStore keeps the audit log.
+type AuditStore interface {
+	RecordAuditEvent(ctx context.Context, e *AuditEvent) error
+
+	// ListAuditEvents returns events matching the filter, newest first.
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error)
+}
+
+// ModerationStore lets moderators find, remove and restore signatures.
+type ModerationStore interface {
+	// SearchSignatures returns signatures matching the filter in signing
+	// order.
+	SearchSignatures(ctx context.Context, f SignatureFilter) ([]SignatureResult, error)
+
+	// RemoveSignature hides a live signature and moves everyone after it up a
+	// place. Fails with ErrSignatureNotFound if the user has no live
+	// signature.
+	RemoveSignature(ctx context.Context, userID string, actorID string, reason string) error
+
+	// RestoreSignature puts a removed signature back at its original place.
+	// Fails with ErrSignatureNotFound if the user has no removed signature.
+	RestoreSignature(ctx context.Context, userID string, actorID string, reason string) error
+
+	// ListModerationActions returns the actions taken on the user's
+	// signature, newest first.
+	ListModerationActions(ctx context.Context, userID string) ([]ModerationAction, error)
+}
+
+// Store is everything the API keeps in its database.
+type Store interface {
+	UserStore
+	SignatureStore
+	BanStore
+	RoleStore
+	APITokenStore
+	AuditStore
+	ModerationStore
+}
@@ -7,3 +7,4 @@ import (
 // TODO: come up with a better system for this
 var DiscordAPIError = errors.New("discord api error")
 var DiscordAPIUnauthorized = errors.New("duscird api unauthorized request")
+var OAuthRefreshFailed = errors.New("oauth token refresh failed")
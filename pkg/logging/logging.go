@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/thankyoudiscord/api/pkg/config"
+)
+
+// context key of the request's logger, see Ctx
+const LOGGER_CONTEXT_KEY = "logger"
+
+// used where no logger was set up, e.g. before the config is loaded. It
+// redacts tokens and cookies but knows no configured secrets.
+var defaultLogger = newLogger(&redactingWriter{out: os.Stdout})
+
+func init() {
+	zerolog.TimeFieldFormat = "2006-01-02T15:04:05.000Z07:00"
+	zerolog.DurationFieldUnit = time.Millisecond
+}
+
+func newLogger(w *redactingWriter) zerolog.Logger {
+	return zerolog.New(w).With().Timestamp().Logger()
+}
+
+// New returns a root logger at the config's log level. It writes JSON lines to
+// stdout with tokens, cookies and the config's secrets redacted. Code handling
+// a request should log through Ctx instead so lines carry the request's ID,
+// user and route.
+func New(conf *config.Config) (zerolog.Logger, error) {
+	level, err := zerolog.ParseLevel(conf.LogLevel)
+	if err != nil {
+		return zerolog.Logger{}, err
+	}
+
+	w := &redactingWriter{out: os.Stdout}
+	w.setSecrets(conf.Secrets())
+
+	return newLogger(w).Level(level), nil
+}
+
+// Default returns the logger used before New can be called, e.g. to report an
+// invalid config.
+func Default() *zerolog.Logger {
+	l := defaultLogger
+	return &l
+}
+
+// WithLogger returns a copy of ctx that Ctx gets l from.
+func WithLogger(ctx context.Context, l *zerolog.Logger) context.Context {
+	return context.WithValue(ctx, LOGGER_CONTEXT_KEY, l)
+}
+
+// Ctx returns the logger of the request ctx belongs to, or the one set with
+// WithLogger, falling back to Default.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	l, ok := ctx.Value(LOGGER_CONTEXT_KEY).(*zerolog.Logger)
+	if !ok {
+		return Default()
+	}
+
+	// the route pattern is only complete once the router has matched, so
+	// it's looked up at log time
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		rl := l.With().Str("route", rctx.RoutePattern()).Logger()
+		return &rl
+	}
+
+	return l
+}
+
+// SetUserID adds the authenticated user to every following line logged for
+// the request, including its access log line.
+func SetUserID(ctx context.Context, userID string) {
+	l, ok := ctx.Value(LOGGER_CONTEXT_KEY).(*zerolog.Logger)
+	if !ok {
+		return
+	}
+
+	l.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("user_id", userID)
+	})
+}
+
+// Middleware gives every request its own logger derived from root, carrying
+// the request and trace IDs. Must be used after chi's RequestID and
+// tracing.Middleware.
+func Middleware(root zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := root.With()
+
+			if id := middleware.GetReqID(r.Context()); id != "" {
+				c = c.Str("request_id", id)
+			}
+
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				c = c.Str("trace_id", sc.TraceID().String())
+			}
+
+			l := c.Logger()
+			ctx := WithLogger(r.Context(), &l)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AccessLog logs one line per request once it's been served.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		l := Ctx(r.Context())
+		e := l.Info()
+		if status >= http.StatusInternalServerError {
+			e = l.Error()
+		}
+
+		e.
+			Str("method", r.Method).
+			Str("path", r.URL.RequestURI()).
+			Int("status", status).
+			Int("bytes", ww.BytesWritten()).
+			Dur("duration_ms", time.Since(start)).
+			Str("remote_addr", r.RemoteAddr).
+			Msg("request")
+	})
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/thankyoudiscord/api/pkg/config"
+)
+
+// secrets shorter than this aren't redacted verbatim, they would mangle
+// unrelated text
+const MIN_SECRET_LENGTH = 8
+
+// fields whose values are always redacted, whatever they contain
+var sensitiveFields = []string{
+	"token",
+	"access_token",
+	"refresh_token",
+	"api_token",
+	"authorization",
+	"cookie",
+	"set_cookie",
+	"session_id",
+	"client_secret",
+	"captcha_secret",
+	"captcha_solution",
+	"password",
+	"webhook",
+}
+
+type redaction struct {
+	pattern *regexp.Regexp
+	repl    string
+}
+
+// applied to whole JSON lines, so values are matched in their escaped form
+var redactions = []redaction{
+	{
+		regexp.MustCompile(`"(` + strings.Join(sensitiveFields, "|") + `)":"(?:[^"\\]|\\.)*"`),
+		`"$1":"` + config.REDACTED + `"`,
+	},
+	// authorization header values
+	{
+		regexp.MustCompile(`(?i)\b(Bearer|Bot) [\w\-.~+/]+=*`),
+		`$1 ` + config.REDACTED,
+	},
+	// discord bot tokens
+	{
+		regexp.MustCompile(`[\w-]{23,28}\.[\w-]{6,7}\.[\w-]{27,}`),
+		config.REDACTED,
+	},
+	// API tokens, see auth.API_TOKEN_PREFIX
+	{
+		regexp.MustCompile(`\btyd_[\w-]+`),
+		`tyd_` + config.REDACTED,
+	},
+	// oauth parameters in URLs and form bodies
+	{
+		regexp.MustCompile(`(?i)\b(code|state|token|access_token|refresh_token|client_secret|secret|response)=[^&\s"\\]+`),
+		`$1=` + config.REDACTED,
+	},
+	// session and login cookies, see auth.SESSION_ID_COOKIE and
+	// auth.LOGIN_STATE_COOKIE
+	{
+		regexp.MustCompile(`\b(session_id|oauth_state)=[^;\s"\\]+`),
+		`$1=` + config.REDACTED,
+	},
+	{
+		regexp.MustCompile(`(/api/webhooks/\d+/)[\w-]+`),
+		`${1}` + config.REDACTED,
+	},
+}
+
+// redactingWriter scrubs credentials from every log line before writing it.
+// zerolog writes each line with a single call to Write.
+type redactingWriter struct {
+	out io.Writer
+
+	mu      sync.RWMutex
+	secrets [][]byte
+}
+
+func (w *redactingWriter) setSecrets(secrets []string) {
+	var escaped [][]byte
+	for _, s := range secrets {
+		if len(s) < MIN_SECRET_LENGTH {
+			continue
+		}
+
+		// match the secret the way it appears inside a JSON string
+		b, err := json.Marshal(s)
+		if err != nil {
+			continue
+		}
+
+		escaped = append(escaped, b[1:len(b)-1])
+	}
+
+	w.mu.Lock()
+	w.secrets = escaped
+	w.mu.Unlock()
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	line := p
+
+	w.mu.RLock()
+	for _, s := range w.secrets {
+		line = bytes.ReplaceAll(line, s, []byte(config.REDACTED))
+	}
+	w.mu.RUnlock()
+
+	for _, r := range redactions {
+		line = r.pattern.ReplaceAll(line, []byte(r.repl))
+	}
+
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
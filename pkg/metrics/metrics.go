@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "thankyoudiscord"
+
+// Banner cache lookup results.
+const (
+	CACHE_HIT   = "hit"
+	CACHE_STALE = "stale"
+	CACHE_MISS  = "miss"
+)
+
+// Discord calls, used as the call label of DiscordRequests.
+const (
+	DISCORD_GET_USER     = "get_user"
+	DISCORD_ADD_ROLE     = "add_role"
+	DISCORD_FEED_WEBHOOK = "feed_webhook"
+	DISCORD_REVOKE_TOKEN = "revoke_token"
+)
+
+// Why a signature left the banner, used as the reason label of
+// SignaturesDeleted.
+const (
+	DELETE_REASON_UNSIGNED = "unsigned"
+	DELETE_REASON_REMOVED  = "removed"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by chi route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	BannerCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "banner_cache_lookups_total",
+		Help:      "Banner cache lookups by result: hit, stale or miss.",
+	}, []string{"result"})
+
+	BannerGenerateDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "banner_generate_duration_seconds",
+		Help:      "Latency of GenerateBanner calls to the banner generator.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	BannerGenerateErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "banner_generate_errors_total",
+		Help:      "Failed GenerateBanner calls to the banner generator.",
+	})
+
+	DiscordRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "discord_requests_total",
+		Help:      "Requests to discord by call and outcome, the status class or error.",
+	}, []string{"call", "outcome"})
+
+	CaptchaVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "captcha_verifications_total",
+		Help:      "Captcha verifications by result: pass or fail.",
+	}, []string{"result"})
+
+	SignaturesCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "signatures_created_total",
+		Help:      "Signatures added to the banner.",
+	})
+
+	SignaturesDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "signatures_deleted_total",
+		Help:      "Signatures taken off the banner by reason: unsigned by their signer or removed by a moderator.",
+	}, []string{"reason"})
+)
+
+// Handler serves the metrics on /metrics. It's meant for the internal listener
+// on METRICS_ADDR, not the public API.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// Middleware records the count and latency of every request, labeled by the
+// chi route pattern so path parameters don't blow up the label cardinality.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		// only known once the router has matched the request
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		HTTPRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveDiscordRequest counts a request to discord by the class of its status
+// code, or as an error if it failed without a response.
+func ObserveDiscordRequest(call string, res *http.Response, err error) {
+	outcome := "error"
+	if err == nil && res != nil {
+		outcome = strconv.Itoa(res.StatusCode/100) + "xx"
+	}
+
+	DiscordRequests.WithLabelValues(call, outcome).Inc()
+}
+
+func ObserveCaptcha(passed bool) {
+	result := "fail"
+	if passed {
+		result = "pass"
+	}
+
+	CaptchaVerifications.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Discord makes requests to the discord API at APIURL, which can be pointed at
+// a local stub of the API.
+type Discord struct {
+	APIURL     string
+	HTTPClient *http.Client
+}
+
+func NewDiscord(apiURL string, client *http.Client) *Discord {
+	return &Discord{
+		APIURL:     strings.TrimSuffix(apiURL, "/"),
+		HTTPClient: client,
+	}
+}
@@ -1,12 +1,13 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	tyderrors "github.com/thankyoudiscord/api/pkg/errors"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/metrics"
 )
 
 type DiscordUser struct {
@@ -24,22 +25,24 @@ type DiscordUser struct {
 	PremiumType   int    `json:"premium_type"`
 }
 
-func GetUser(at string) (*DiscordUser, error) {
-	req, err := http.NewRequest("GET", "https://discord.com/api/v9/users/@me", nil)
+func (d *Discord) GetUser(ctx context.Context, at string) (*DiscordUser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.APIURL+"/v9/users/@me", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Add("Authorization", "Bearer "+at)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := d.HTTPClient.Do(req)
+	metrics.ObserveDiscordRequest(metrics.DISCORD_GET_USER, res, err)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		bdy, _ := ioutil.ReadAll(res.Body)
-		fmt.Println("discord responded with non-200 status code:", res.StatusCode, string(bdy))
+		// the body isn't logged, discord may echo back parts of the request
+		logging.Ctx(ctx).Warn().Int("status", res.StatusCode).Msg("discord responded with non-200 status code")
 
 		if res.StatusCode == http.StatusUnauthorized {
 			return nil, tyderrors.DiscordAPIUnauthorized
@@ -0,0 +1,220 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+// AdminRoutes is the admin API. Everything mounted here requires at least the
+// viewer role, routes that change anything should require more.
+type AdminRoutes struct {
+	App *app.App
+}
+
+func (ar AdminRoutes) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(ar.App.Auth.Authenticated)
+	r.Use(auth.RequireScope(auth.SCOPE_ADMIN))
+	r.Use(ar.App.Auth.RequireRole(auth.ROLE_VIEWER))
+
+	r.Mount("/signatures", AdminSignatureRoutes{App: ar.App}.Routes())
+	r.Mount("/bans", AdminBanRoutes{App: ar.App}.Routes())
+
+	r.Get("/roles", ar.ListRoles)
+	r.Group(func(r chi.Router) {
+		r.Use(ar.App.Auth.RequireRole(auth.ROLE_ADMIN))
+
+		r.Get("/audit", ar.ListAuditEvents)
+		r.Put("/roles/{userID}", ar.SetRole)
+		r.Delete("/roles/{userID}", ar.RemoveRole)
+	})
+
+	return r
+}
+
+func (ar AdminRoutes) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := ar.App.Auth.ListUserRoles(r.Context())
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to list roles")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(roles)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
+type SetRolePayload struct {
+	Role string `json:"role"`
+}
+
+func (ar AdminRoutes) SetRole(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+	userID := chi.URLParam(r, "userID")
+
+	var body SetRolePayload
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("Failed to parse JSON payload"))
+		return
+	}
+
+	err = ar.App.Auth.SetUserRole(r.Context(), userID, body.Role, &session.UserID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownRole) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError("Unknown role"))
+			return
+		}
+
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to set role")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(ar.App.Audit, r, session.UserID, database.AUDIT_ROLE_SET, userID, map[string]interface{}{
+		"role": body.Role,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ar AdminRoutes) RemoveRole(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+	userID := chi.URLParam(r, "userID")
+
+	found, err := ar.App.Auth.RemoveUserRole(r.Context(), userID)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to remove role")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(models.CreateError("User has no role"))
+		return
+	}
+
+	recordAudit(ar.App.Audit, r, session.UserID, database.AUDIT_ROLE_REMOVE, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTimeRange reads the optional from and to query parameters as RFC 3339
+// timestamps.
+func parseTimeRange(q url.Values) (*time.Time, *time.Time, error) {
+	var times [2]*time.Time
+	for i, name := range []string{"from", "to"} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v must be an RFC 3339 timestamp", name)
+		}
+
+		times[i] = &t
+	}
+
+	return times[0], times[1], nil
+}
+
+const DEFAULT_AUDIT_PAGE_SIZE = 100
+const MAX_AUDIT_PAGE_SIZE = 1000
+
+type AuditEventsPayload struct {
+	Events []database.AuditEvent `json:"events"`
+
+	// pass as ?cursor= to get the next page, nil on the last page
+	NextCursor *string `json:"next_cursor"`
+}
+
+// ListAuditEvents returns audit events newest first. It takes the query
+// parameters actor_id, action, target, from and to (RFC 3339), limit and
+// cursor.
+func (ar AdminRoutes) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f := database.AuditFilter{
+		ActorID: q.Get("actor_id"),
+		Action:  q.Get("action"),
+		Target:  q.Get("target"),
+		Limit:   DEFAULT_AUDIT_PAGE_SIZE,
+	}
+
+	from, to, err := parseTimeRange(q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError(err.Error()))
+		return
+	}
+
+	f.From, f.To = from, to
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MAX_AUDIT_PAGE_SIZE {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError(fmt.Sprintf("limit must be between 1 and %v", MAX_AUDIT_PAGE_SIZE)))
+			return
+		}
+
+		f.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError("Invalid cursor"))
+			return
+		}
+
+		f.Before = before
+	}
+
+	events, err := ar.App.Audit.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to list audit events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	pl := AuditEventsPayload{Events: events}
+	if len(events) == f.Limit {
+		cursor := strconv.FormatUint(events[len(events)-1].ID, 10)
+		pl.NextCursor = &cursor
+	}
+
+	b, err := json.Marshal(pl)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+// AdminBanRoutes manages the users who aren't allowed to sign. It is mounted on
+// the admin router.
+type AdminBanRoutes struct {
+	App *app.App
+}
+
+func (abr AdminBanRoutes) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", abr.ListBans)
+	r.Group(func(r chi.Router) {
+		r.Use(abr.App.Auth.RequireRole(auth.ROLE_MODERATOR))
+
+		r.Put("/{userID}", abr.BanUser)
+		r.Delete("/{userID}", abr.UnbanUser)
+	})
+
+	return r
+}
+
+// ListBans lists active bans, or all bans with ?expired=true.
+func (abr AdminBanRoutes) ListBans(w http.ResponseWriter, r *http.Request) {
+	includeExpired := r.URL.Query().Get("expired") == "true"
+
+	bans, err := abr.App.Bans.ListBans(r.Context(), includeExpired)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to list bans")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(bans)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
+type BanPayload struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (abr AdminBanRoutes) BanUser(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+
+	var body BanPayload
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("Failed to parse JSON payload"))
+		return
+	}
+
+	if len(body.Reason) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("A reason is required"))
+		return
+	}
+
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("expires_at must be in the future"))
+		return
+	}
+
+	ban := database.Ban{
+		UserID:    chi.URLParam(r, "userID"),
+		Reason:    body.Reason,
+		ExpiresAt: body.ExpiresAt,
+		BannedBy:  session.UserID,
+	}
+
+	if err := abr.App.Bans.BanUser(r.Context(), &ban); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to ban user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(abr.App.Audit, r, session.UserID, database.AUDIT_BAN_CREATE, ban.UserID, map[string]interface{}{
+		"reason":     ban.Reason,
+		"expires_at": ban.ExpiresAt,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (abr AdminBanRoutes) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+	userID := chi.URLParam(r, "userID")
+
+	found, err := abr.App.Bans.UnbanUser(r.Context(), userID)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to unban user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(models.CreateError("User is not banned"))
+		return
+	}
+
+	recordAudit(abr.App.Audit, r, session.UserID, database.AUDIT_BAN_DELETE, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,201 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/metrics"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+const DEFAULT_SIGNATURE_PAGE_SIZE = 50
+const MAX_SIGNATURE_PAGE_SIZE = 500
+
+// AdminSignatureRoutes lets moderators find and remove bad signatures. It is
+// mounted on the admin router.
+type AdminSignatureRoutes struct {
+	App *app.App
+}
+
+func (asr AdminSignatureRoutes) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", asr.ListSignatures)
+	r.Get("/{userID}/actions", asr.ListActions)
+
+	r.Group(func(r chi.Router) {
+		r.Use(asr.App.Auth.RequireRole(auth.ROLE_MODERATOR))
+
+		r.Post("/{userID}/remove", asr.RemoveSignature)
+		r.Post("/{userID}/restore", asr.RestoreSignature)
+	})
+
+	return r
+}
+
+// ListSignatures searches signatures. It takes the query parameters user_id,
+// username, referrer_id, from and to (RFC 3339), status (active, removed or
+// all), limit and offset.
+func (asr AdminSignatureRoutes) ListSignatures(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f := database.SignatureFilter{
+		UserID:     q.Get("user_id"),
+		Username:   q.Get("username"),
+		ReferrerID: q.Get("referrer_id"),
+		Limit:      DEFAULT_SIGNATURE_PAGE_SIZE,
+	}
+
+	switch q.Get("status") {
+	case "", "active":
+	case "removed":
+		f.OnlyRemoved = true
+	case "all":
+		f.IncludeRemoved = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("status must be active, removed or all"))
+		return
+	}
+
+	from, to, err := parseTimeRange(q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError(err.Error()))
+		return
+	}
+
+	f.From, f.To = from, to
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MAX_SIGNATURE_PAGE_SIZE {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError(fmt.Sprintf("limit must be between 1 and %v", MAX_SIGNATURE_PAGE_SIZE)))
+			return
+		}
+
+		f.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError("offset must not be negative"))
+			return
+		}
+
+		f.Offset = offset
+	}
+
+	sigs, err := asr.App.Moderation.SearchSignatures(r.Context(), f)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to search signatures")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(sigs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (asr AdminSignatureRoutes) ListActions(w http.ResponseWriter, r *http.Request) {
+	actions, err := asr.App.Moderation.ListModerationActions(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to list moderation actions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(actions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
+type ModerationPayload struct {
+	Reason string `json:"reason"`
+}
+
+func (asr AdminSignatureRoutes) RemoveSignature(w http.ResponseWriter, r *http.Request) {
+	asr.moderate(w, r, database.AUDIT_SIGNATURE_REMOVE, func(ctx context.Context, userID string, actorID string, reason string) error {
+		err := asr.App.Moderation.RemoveSignature(ctx, userID, actorID, reason)
+		if err == nil {
+			metrics.SignaturesDeleted.WithLabelValues(metrics.DELETE_REASON_REMOVED).Inc()
+		}
+
+		return err
+	})
+}
+
+func (asr AdminSignatureRoutes) RestoreSignature(w http.ResponseWriter, r *http.Request) {
+	asr.moderate(w, r, database.AUDIT_SIGNATURE_RESTORE, asr.App.Moderation.RestoreSignature)
+}
+
+func (asr AdminSignatureRoutes) moderate(
+	w http.ResponseWriter,
+	r *http.Request,
+	auditAction string,
+	action func(ctx context.Context, userID string, actorID string, reason string) error,
+) {
+	session := r.Context().Value("session").(*auth.Session)
+	userID := chi.URLParam(r, "userID")
+
+	var body ModerationPayload
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("Failed to parse JSON payload"))
+		return
+	}
+
+	if len(body.Reason) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("A reason is required"))
+		return
+	}
+
+	err = action(r.Context(), userID, session.UserID, body.Reason)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(models.CreateError("Signature not found"))
+			return
+		}
+
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to moderate signature")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(asr.App.Audit, r, session.UserID, auditAction, userID, map[string]interface{}{
+		"reason": body.Reason,
+	})
+
+	if err := asr.App.BannerCache.Invalidate(r.Context()); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to invalidate banner cache")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,368 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"golang.org/x/oauth2"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/cache"
+	"github.com/thankyoudiscord/api/pkg/config"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+const (
+	ADMIN_ID     = "100000000000000001"
+	MODERATOR_ID = "100000000000000002"
+	VIEWER_ID    = "100000000000000003"
+	SIGNER_ID    = "100000000000000004"
+)
+
+// testApp is an app on top of the memory store, with a token for an admin, a
+// moderator and a viewer, and a user who has signed.
+type testApp struct {
+	*app.App
+	store  *database.MemoryStore
+	router http.Handler
+	tokens map[string]string
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	store := database.NewMemoryStore()
+
+	a := &app.App{
+		Config:      &config.Config{AppEnv: "development", DatabaseStore: "memory"},
+		Logger:      zerolog.Nop(),
+		HTTPClient:  http.DefaultClient,
+		Redis:       rdb,
+		Users:       store,
+		Signatures:  store,
+		Bans:        store,
+		Audit:       store,
+		Moderation:  store,
+		BannerCache: cache.NewBannerCache(rdb),
+	}
+
+	// nothing here should reach discord
+	a.Discord = models.NewDiscord("http://127.0.0.1:1", a.HTTPClient)
+	// cookie sessions find their user in the cache, see login
+	a.Auth = auth.NewAuthManager(auth.NewMemorySessionStore(), &oauth2.Config{}, store, a.Discord, time.Hour, 0, false)
+
+	ta := &testApp{App: a, store: store, tokens: map[string]string{}}
+	ta.router = NewRouter(a)
+
+	ctx := context.Background()
+	for id, role := range map[string]string{
+		ADMIN_ID:     auth.ROLE_ADMIN,
+		MODERATOR_ID: auth.ROLE_MODERATOR,
+		VIEWER_ID:    auth.ROLE_VIEWER,
+	} {
+		ta.addUser(t, id)
+		if err := a.Auth.SetUserRole(ctx, id, role, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		token, _, err := a.Auth.CreateAPIToken(ctx, id, "test", []string{auth.SCOPE_ADMIN}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ta.tokens[id] = token
+	}
+
+	ta.addUser(t, SIGNER_ID)
+	if err := store.CreateSignature(ctx, &database.Signature{UserID: SIGNER_ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	return ta
+}
+
+func (ta *testApp) addUser(t *testing.T, id string) {
+	t.Helper()
+
+	err := ta.store.UpsertUser(context.Background(), &database.User{
+		UserID:        id,
+		Username:      "user" + id[len(id)-1:],
+		Discriminator: "0001",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends a request through the router as userID, or without a token if
+// userID is empty.
+func (ta *testApp) do(t *testing.T, userID string, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if userID != "" {
+		req.Header.Set("Authorization", "Bearer "+ta.tokens[userID])
+	}
+
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+
+	return w
+}
+
+// login creates a cookie session for userID. Its profile is cached, so
+// Authenticated doesn't ask discord for it.
+func (ta *testApp) login(t *testing.T, userID string) *http.Cookie {
+	t.Helper()
+
+	ctx := context.Background()
+	id, expiresAt, err := ta.Auth.CreateSession(ctx, auth.Session{
+		UserID:       userID,
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+	}, auth.SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ta.Auth.SetCachedUser(ctx, id, &models.DiscordUser{ID: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &http.Cookie{Name: auth.SESSION_ID_COOKIE, Value: id, Expires: expiresAt}
+}
+
+// callAs calls a handler that isn't mounted with the context Authenticated
+// would have given it.
+func callAs(userID string, h http.HandlerFunc, method string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/banner/sign", strings.NewReader(body))
+
+	ctx := context.WithValue(req.Context(), "session", &auth.Session{UserID: userID})
+	ctx = context.WithValue(ctx, "user", &models.DiscordUser{ID: userID, Username: "signer"})
+
+	w := httptest.NewRecorder()
+	h(w, req.WithContext(ctx))
+
+	return w
+}
+
+func assertStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if w.Code != want {
+		t.Fatalf("got status %v, want %v: %s", w.Code, want, w.Body.String())
+	}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body.String(), err)
+	}
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	ta := newTestApp(t)
+	ctx := context.Background()
+
+	assertStatus(t, ta.do(t, "", "GET", "/admin/bans", ""), http.StatusUnauthorized)
+
+	ta.tokens["invalid"] = "tyd_not-a-real-token"
+	assertStatus(t, ta.do(t, "invalid", "GET", "/admin/bans", ""), http.StatusUnauthorized)
+
+	// a token without the admin scope
+	token, _, err := ta.Auth.CreateAPIToken(ctx, ADMIN_ID, "read only", []string{auth.SCOPE_USER_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta.tokens["read only"] = token
+	assertStatus(t, ta.do(t, "read only", "GET", "/admin/bans", ""), http.StatusForbidden)
+
+	// a user without a role
+	token, _, err = ta.Auth.CreateAPIToken(ctx, SIGNER_ID, "test", []string{auth.SCOPE_ADMIN}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta.tokens[SIGNER_ID] = token
+	assertStatus(t, ta.do(t, SIGNER_ID, "GET", "/admin/bans", ""), http.StatusForbidden)
+
+	// a revoked token
+	tokens, err := ta.Auth.ListAPITokens(ctx, VIEWER_ID)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("got %v tokens, %v", len(tokens), err)
+	}
+
+	assertStatus(t, ta.do(t, VIEWER_ID, "GET", "/admin/bans", ""), http.StatusOK)
+
+	if _, err := ta.Auth.RevokeAPIToken(ctx, VIEWER_ID, tokens[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatus(t, ta.do(t, VIEWER_ID, "GET", "/admin/bans", ""), http.StatusUnauthorized)
+}
+
+func TestAdminRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"viewer lists bans", VIEWER_ID, "GET", "/admin/bans", "", http.StatusOK},
+		{"viewer lists signatures", VIEWER_ID, "GET", "/admin/signatures", "", http.StatusOK},
+		{"viewer lists roles", VIEWER_ID, "GET", "/admin/roles", "", http.StatusOK},
+		{"viewer can't ban", VIEWER_ID, "PUT", "/admin/bans/" + SIGNER_ID, `{"reason":"spam"}`, http.StatusForbidden},
+		{"viewer can't remove signatures", VIEWER_ID, "POST", "/admin/signatures/" + SIGNER_ID + "/remove", `{"reason":"spam"}`, http.StatusForbidden},
+		{"moderator can't read the audit log", MODERATOR_ID, "GET", "/admin/audit", "", http.StatusForbidden},
+		{"moderator can't set roles", MODERATOR_ID, "PUT", "/admin/roles/" + SIGNER_ID, `{"role":"viewer"}`, http.StatusForbidden},
+		{"admin reads the audit log", ADMIN_ID, "GET", "/admin/audit", "", http.StatusOK},
+	}
+
+	ta := newTestApp(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertStatus(t, ta.do(t, tt.userID, tt.method, tt.path, tt.body), tt.want)
+		})
+	}
+}
+
+func TestAdminBans(t *testing.T) {
+	ta := newTestApp(t)
+
+	assertStatus(t, ta.do(t, MODERATOR_ID, "PUT", "/admin/bans/"+SIGNER_ID, `{}`), http.StatusBadRequest)
+	assertStatus(t, ta.do(t, MODERATOR_ID, "PUT", "/admin/bans/"+SIGNER_ID, `{"reason":"spam"}`), http.StatusNoContent)
+
+	var bans []database.Ban
+	decode(t, ta.do(t, VIEWER_ID, "GET", "/admin/bans", ""), &bans)
+	if len(bans) != 1 || bans[0].UserID != SIGNER_ID || bans[0].BannedBy != MODERATOR_ID {
+		t.Fatalf("got bans %+v", bans)
+	}
+
+	// banned users can't sign until they are unbanned
+	br := BannerRoutes{App: ta.App}
+	assertStatus(t, ta.do(t, MODERATOR_ID, "PUT", "/admin/bans/"+VIEWER_ID, `{"reason":"spam"}`), http.StatusNoContent)
+	w := callAs(VIEWER_ID, br.SignBanner, "POST", `{}`)
+	assertStatus(t, w, http.StatusForbidden)
+	if !strings.Contains(w.Body.String(), "spam") {
+		t.Errorf("ban reason missing from %s", w.Body.String())
+	}
+
+	assertStatus(t, ta.do(t, MODERATOR_ID, "DELETE", "/admin/bans/"+VIEWER_ID, ""), http.StatusNoContent)
+	assertStatus(t, ta.do(t, MODERATOR_ID, "DELETE", "/admin/bans/"+VIEWER_ID, ""), http.StatusNotFound)
+	assertStatus(t, callAs(VIEWER_ID, br.SignBanner, "POST", `{}`), http.StatusOK)
+	assertStatus(t, callAs(VIEWER_ID, br.SignBanner, "POST", `{}`), http.StatusUnprocessableEntity)
+
+	var pl AuditEventsPayload
+	decode(t, ta.do(t, ADMIN_ID, "GET", "/admin/audit?target="+VIEWER_ID, ""), &pl)
+
+	var actions []string
+	for _, e := range pl.Events {
+		actions = append(actions, e.Action)
+	}
+
+	want := []string{database.AUDIT_SIGNATURE_CREATE, database.AUDIT_BAN_DELETE, database.AUDIT_BAN_CREATE}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("got audit actions %v, want %v", actions, want)
+	}
+}
+
+func TestAdminSignatureModeration(t *testing.T) {
+	ta := newTestApp(t)
+	br := BannerRoutes{App: ta.App}
+
+	count := func() int64 {
+		t.Helper()
+
+		var stats map[string]int64
+		decode(t, ta.do(t, "", "GET", "/stats", ""), &stats)
+		return stats["signatures"]
+	}
+
+	if n := count(); n != 1 {
+		t.Fatalf("got %v signatures, want 1", n)
+	}
+
+	remove := "/admin/signatures/" + SIGNER_ID + "/remove"
+	restore := "/admin/signatures/" + SIGNER_ID + "/restore"
+
+	assertStatus(t, ta.do(t, MODERATOR_ID, "POST", remove, `{}`), http.StatusBadRequest)
+	assertStatus(t, ta.do(t, MODERATOR_ID, "POST", "/admin/signatures/1/remove", `{"reason":"spam"}`), http.StatusNotFound)
+	assertStatus(t, ta.do(t, MODERATOR_ID, "POST", remove, `{"reason":"spam"}`), http.StatusNoContent)
+	assertStatus(t, ta.do(t, MODERATOR_ID, "POST", remove, `{"reason":"spam"}`), http.StatusNotFound)
+
+	if n := count(); n != 0 {
+		t.Fatalf("got %v signatures after removing, want 0", n)
+	}
+
+	var sigs []database.SignatureResult
+	decode(t, ta.do(t, VIEWER_ID, "GET", "/admin/signatures?status=removed", ""), &sigs)
+	if len(sigs) != 1 || sigs[0].UserID != SIGNER_ID || sigs[0].RemovedAt == nil {
+		t.Fatalf("got removed signatures %+v", sigs)
+	}
+
+	// removed signatures can't be unsigned or signed again
+	assertStatus(t, callAs(SIGNER_ID, br.UnsignBanner, "DELETE", ""), http.StatusConflict)
+	assertStatus(t, callAs(SIGNER_ID, br.SignBanner, "POST", `{}`), http.StatusConflict)
+
+	assertStatus(t, ta.do(t, MODERATOR_ID, "POST", restore, `{"reason":"mistake"}`), http.StatusNoContent)
+
+	if n := count(); n != 1 {
+		t.Fatalf("got %v signatures after restoring, want 1", n)
+	}
+
+	var actions []database.ModerationAction
+	decode(t, ta.do(t, VIEWER_ID, "GET", "/admin/signatures/"+SIGNER_ID+"/actions", ""), &actions)
+	if len(actions) != 2 {
+		t.Fatalf("got moderation actions %+v", actions)
+	}
+
+	assertStatus(t, callAs(SIGNER_ID, br.UnsignBanner, "DELETE", ""), http.StatusOK)
+
+	if n := count(); n != 0 {
+		t.Fatalf("got %v signatures after unsigning, want 0", n)
+	}
+}
+
+func TestAdminAuditPagination(t *testing.T) {
+	ta := newTestApp(t)
+
+	for _, id := range []string{VIEWER_ID, SIGNER_ID, MODERATOR_ID} {
+		assertStatus(t, ta.do(t, ADMIN_ID, "PUT", "/admin/bans/"+id, `{"reason":"spam"}`), http.StatusNoContent)
+	}
+
+	var page AuditEventsPayload
+	decode(t, ta.do(t, ADMIN_ID, "GET", "/admin/audit?limit=2", ""), &page)
+	if len(page.Events) != 2 || page.NextCursor == nil {
+		t.Fatalf("got first page %+v", page)
+	}
+
+	if page.Events[0].Target != MODERATOR_ID || page.Events[1].Target != SIGNER_ID {
+		t.Errorf("first page isn't newest first: %+v", page.Events)
+	}
+
+	var next AuditEventsPayload
+	decode(t, ta.do(t, ADMIN_ID, "GET", "/admin/audit?limit=2&cursor="+*page.NextCursor, ""), &next)
+	if len(next.Events) != 1 || next.Events[0].Target != VIEWER_ID || next.NextCursor != nil {
+		t.Fatalf("got second page %+v", next)
+	}
+
+	assertStatus(t, ta.do(t, ADMIN_ID, "GET", "/admin/audit?cursor=nope", ""), http.StatusBadRequest)
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+)
+
+// recordAudit writes an audit event for the request. Failing to write one is
+// logged but doesn't fail the request, the operation already happened.
+func recordAudit(store database.AuditStore, r *http.Request, actorID string, action string, target string, metadata map[string]interface{}) {
+	e := database.AuditEvent{
+		Action: action,
+		Target: target,
+		IP:     auth.RequestIP(r),
+	}
+
+	if actorID != "" {
+		e.ActorID = &actorID
+	}
+
+	if metadata != nil {
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			logging.Ctx(r.Context()).Error().Err(err).Msg("failed to encode audit metadata")
+		} else {
+			e.Metadata = b
+		}
+	}
+
+	if err := store.RecordAuditEvent(r.Context(), &e); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Str("action", action).Msg("failed to record audit event")
+	}
+}
@@ -1,56 +1,77 @@
 package routes
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 
+	"github.com/thankyoudiscord/api/pkg/app"
 	"github.com/thankyoudiscord/api/pkg/auth"
 	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
 	"github.com/thankyoudiscord/api/pkg/models"
 )
 
-type AuthRoutes struct{}
-
-var oauthConf *oauth2.Config
-
-func init() {
-	godotenv.Load()
-	oauthConf = &oauth2.Config{
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("REDIRECT_URI"),
-		Scopes:       []string{"identify"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://discord.com/api/oauth2/authorize",
-			TokenURL: "https://discord.com/api/oauth2/token",
-		},
-	}
-
+type AuthRoutes struct {
+	App *app.App
 }
 
 func (ar AuthRoutes) Routes() chi.Router {
 	r := chi.NewRouter()
 
+	r.Get("/login/url", ar.LoginURL)
 	r.Post("/login", ar.Login)
 	r.Group(func(r chi.Router) {
-		r.Use(auth.Authenticated)
+		r.Use(ar.App.Auth.Authenticated)
+		r.Use(auth.RequireSession)
 		r.Post("/logout", ar.Logout)
 	})
 
 	return r
 }
 
+type LoginURLPayload struct {
+	URL string `json:"url"`
+}
+
+func (ar AuthRoutes) LoginURL(w http.ResponseWriter, r *http.Request) {
+	mgr := ar.App.Auth
+
+	url, state, err := mgr.CreateLoginURL(r.Context())
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to create login state")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(LoginURLPayload{URL: url})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// bind the state to this browser so a code obtained elsewhere can't be
+	// injected into it
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.LOGIN_STATE_COOKIE,
+		SameSite: http.SameSiteNoneMode,
+		Secure:   true,
+		Value:    state,
+		Path:     "/",
+		Expires:  time.Now().Add(auth.LOGIN_STATE_TTL),
+		HttpOnly: true,
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
 type LoginPayload struct {
-	Code string `json:"code"`
+	Code  string `json:"code"`
+	State string `json:"state"`
 }
 
 func (ar AuthRoutes) Login(w http.ResponseWriter, r *http.Request) {
@@ -69,36 +90,90 @@ func (ar AuthRoutes) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	stateCookie, err := r.Cookie(auth.LOGIN_STATE_COOKIE)
+	if err != nil || len(pl.State) == 0 || stateCookie.Value != pl.State {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(models.CreateError("Invalid OAuth state"))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   auth.LOGIN_STATE_COOKIE,
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	ctx := r.Context()
+	mgr := ar.App.Auth
+
+	verifier, err := mgr.ConsumeLoginState(ctx, pl.State)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to read login state from redis")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(verifier) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(models.CreateError("Invalid OAuth state"))
+		return
+	}
 
-	tok, err := oauthConf.Exchange(ctx, code)
+	tok, err := mgr.OAuthConfig.Exchange(
+		mgr.OAuthContext(ctx),
+		code,
+		oauth2.SetAuthURLParam("code_verifier", verifier),
+	)
 	if err != nil {
-		log.Printf("failed to exchange code: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to exchange code")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	userData, err := models.GetUser(tok.AccessToken)
+	userData, err := mgr.Discord.GetUser(ctx, tok.AccessToken)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to get user from discord")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	blocked, err := mgr.IsLoginBlocked(ctx, userData.ID)
 	if err != nil {
-		fmt.Printf("failed to get user from discord: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to check bans")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	mgr := auth.GetManager()
+	if blocked {
+		mgr.RevokeTokens(ctx, &auth.Session{
+			AccessToken:  tok.AccessToken,
+			RefreshToken: tok.RefreshToken,
+		})
 
-	sID, err := mgr.CreateSession(auth.Session{
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(models.CreateError("You have been banned"))
+		return
+	}
+
+	sID, expiresAt, err := mgr.CreateSession(ctx, auth.Session{
 		AccessToken:  tok.AccessToken,
 		RefreshToken: tok.RefreshToken,
 		UserID:       userData.ID,
+	}, auth.SessionInfo{
+		IP:        auth.RequestIP(r),
+		UserAgent: r.UserAgent(),
 	})
 
 	if err != nil {
-		fmt.Println("failed to save session in redis:", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to save session in redis")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	if err := mgr.SetCachedUser(ctx, sID, userData); err != nil {
+		logging.Ctx(ctx).Warn().Err(err).Msg("failed to cache user")
+	}
+
 	dbUser := database.User{
 		UserID:        userData.ID,
 		Username:      userData.Username,
@@ -106,28 +181,18 @@ func (ar AuthRoutes) Login(w http.ResponseWriter, r *http.Request) {
 		AvatarHash:    userData.Avatar,
 	}
 
-	db := database.GetDatabase()
-	res := db.Model(&dbUser).Where("user_id = ?", dbUser.UserID).Updates(&dbUser)
-	if res.RowsAffected == 0 {
-		res = db.Create(&dbUser)
-	}
-
-	if res.Error != nil {
-		fmt.Printf("failed to update user data in database: %v\n", res.Error)
+	if err := ar.App.Users.UpsertUser(ctx, &dbUser); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to update user data in database")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name: auth.SESSION_ID_COOKIE,
-		// TODO: is this bad?
-		SameSite: http.SameSiteNoneMode,
-		Secure:   true,
-		Value:    sID,
-		Path:     "/",
-		Expires:  time.Now().Add(auth.SESSION_TTL),
-		HttpOnly: true,
+	recordAudit(ar.App.Audit, r, userData.ID, database.AUDIT_LOGIN, userData.ID, map[string]interface{}{
+		"session":    auth.PublicSessionID(sID),
+		"user_agent": r.UserAgent(),
 	})
+
+	auth.SetSessionCookie(w, sID, expiresAt)
 }
 
 func (ar AuthRoutes) Logout(w http.ResponseWriter, r *http.Request) {
@@ -147,6 +212,15 @@ func (ar AuthRoutes) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mgr := auth.GetManager()
-	mgr.DeleteSession(sId)
+	mgr := ar.App.Auth
+	if err := mgr.EndSession(r.Context(), sId); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to end session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session := r.Context().Value("session").(*auth.Session)
+	recordAudit(ar.App.Audit, r, session.UserID, database.AUDIT_LOGOUT, session.UserID, map[string]interface{}{
+		"session": auth.PublicSessionID(sId),
+	})
 }
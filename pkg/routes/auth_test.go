@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+// stubDiscord logs everyone in as SIGNER_ID and records revoked tokens.
+type stubDiscord struct {
+	mu      sync.Mutex
+	revoked []string
+}
+
+func (sd *stubDiscord) revokedTokens() []string {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return append([]string{}, sd.revoked...)
+}
+
+func useStubDiscord(t *testing.T, ta *testApp) *stubDiscord {
+	t.Helper()
+
+	sd := &stubDiscord{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access",
+			"refresh_token": "refresh",
+			"token_type":    "Bearer",
+			"expires_in":    604800,
+		})
+	})
+	mux.HandleFunc("/oauth2/token/revoke", func(w http.ResponseWriter, r *http.Request) {
+		sd.mu.Lock()
+		defer sd.mu.Unlock()
+
+		sd.revoked = append(sd.revoked, r.FormValue("token"))
+	})
+	mux.HandleFunc("/v9/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.DiscordUser{ID: SIGNER_ID, Username: "signer", Discriminator: "0001"})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// Login and RevokeTokens read these through App.Auth on every request
+	ta.Auth.Discord = models.NewDiscord(srv.URL, srv.Client())
+	ta.Auth.OAuthConfig = &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   srv.URL + "/oauth2/authorize",
+			TokenURL:  srv.URL + "/oauth2/token",
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+	}
+
+	return sd
+}
+
+// logIn goes through the login URL and the login callback.
+func (ta *testApp) logIn(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, httptest.NewRequest("GET", "/login/url", nil))
+	assertStatus(t, w, http.StatusOK)
+
+	var pl LoginURLPayload
+	decode(t, w, &pl)
+
+	u, err := url.Parse(pl.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(LoginPayload{Code: "code", State: u.Query().Get("state")})
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(string(body)))
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	w = httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	ta := newTestApp(t)
+	sd := useStubDiscord(t, ta)
+
+	w := ta.logIn(t)
+	assertStatus(t, w, http.StatusOK)
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.SESSION_ID_COOKIE {
+			cookie = c
+		}
+	}
+
+	if cookie == nil {
+		t.Fatal("no session cookie was set")
+	}
+
+	s, err := ta.Auth.GetSession(context.Background(), cookie.Value)
+	if err != nil || s == nil || s.UserID != SIGNER_ID || s.AccessToken != "access" {
+		t.Errorf("got session %+v, %v", s, err)
+	}
+
+	if got := sd.revokedTokens(); len(got) != 0 {
+		t.Errorf("revoked %v on a successful login", got)
+	}
+}
+
+func TestLoginBanned(t *testing.T) {
+	ta := newTestApp(t)
+	sd := useStubDiscord(t, ta)
+	ta.Auth.BansBlockLogin = true
+
+	err := ta.store.BanUser(context.Background(), &database.Ban{UserID: SIGNER_ID, Reason: "spam", BannedBy: ADMIN_ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := ta.logIn(t)
+	assertStatus(t, w, http.StatusForbidden)
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.SESSION_ID_COOKIE {
+			t.Errorf("banned user got a session cookie %v", c)
+		}
+	}
+
+	sessions, err := ta.Auth.ListSessions(context.Background(), SIGNER_ID)
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("banned user got sessions %+v, %v", sessions, err)
+	}
+
+	// the tokens discord handed out for the login are thrown away
+	if got := sd.revokedTokens(); len(got) != 2 || got[0] != "refresh" || got[1] != "access" {
+		t.Errorf("revoked %v, want the refresh and access token", got)
+	}
+}
@@ -7,35 +7,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/jackc/pgconn"
-	"github.com/joho/godotenv"
+	"github.com/thankyoudiscord/api/pkg/app"
 	"github.com/thankyoudiscord/api/pkg/auth"
-	"github.com/thankyoudiscord/api/pkg/cache"
+	"github.com/thankyoudiscord/api/pkg/config"
 	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/metrics"
 	"github.com/thankyoudiscord/api/pkg/models"
 	"github.com/thankyoudiscord/api/pkg/protos"
 )
 
-func init() {
-	// TODO: is it okay to call this in every file?
-	godotenv.Load()
-}
-
 type BannerRoutes struct {
-	bannerGenClient protos.BannerClient
+	App *app.App
 }
 
-func NewBannerRoutes(bannerGenClient protos.BannerClient) *BannerRoutes {
+func NewBannerRoutes(a *app.App) *BannerRoutes {
 	return &BannerRoutes{
-		bannerGenClient: bannerGenClient,
+		App: a,
 	}
 }
 
@@ -43,9 +38,10 @@ func (br BannerRoutes) Routes() chi.Router {
 	r := chi.NewRouter()
 
 	// r.Group(func(r chi.Router) {
-	// 	r.Use(auth.Authenticated)
+	// 	r.Use(br.App.Auth.Authenticated)
 
 	// 	r.Group(func(r chi.Router) {
+	// 		r.Use(auth.RequireScope(auth.SCOPE_SIGNATURE_WRITE))
 	// 		r.Use(httprate.Limit(2, 5*time.Minute, httprate.WithKeyFuncs(
 	// 			httprate.KeyByEndpoint,
 	// 			func(r *http.Request) (string, error) {
@@ -57,7 +53,7 @@ func (br BannerRoutes) Routes() chi.Router {
 	// 		// r.Post("/sign", br.SignBanner)
 	// 	})
 
-	// 	// r.Delete("/sign", br.UnsignBanner)
+	// 	// r.With(auth.RequireScope(auth.SCOPE_SIGNATURE_WRITE)).Delete("/sign", br.UnsignBanner)
 	// })
 
 	r.Get("/image.png", br.GenerateBanner)
@@ -72,16 +68,28 @@ func (br BannerRoutes) SignBanner(w http.ResponseWriter, r *http.Request) {
 	user = r.Context().Value("user").(*models.DiscordUser)
 	userId := session.UserID
 
-	db := database.GetDatabase()
 	sig := database.Signature{
 		UserID: userId,
 	}
 
+	ban, err := br.App.Bans.GetActiveBan(r.Context(), userId)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to check bans")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if ban != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(models.CreateError("You have been banned from signing the banner: " + ban.Reason))
+		return
+	}
+
 	var body struct {
 		Referrer        *string `json:"referrer"`
 		CaptchaSolution string  `json:"captchaSolution"`
 	}
-	err := json.NewDecoder(r.Body).Decode(&body)
+	err = json.NewDecoder(r.Body).Decode(&body)
 
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -89,7 +97,7 @@ func (br BannerRoutes) SignBanner(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if os.Getenv("APP_ENV") == "production" {
+	if br.App.Config.IsProduction() {
 		solution := body.CaptchaSolution
 		if body.CaptchaSolution == "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -97,7 +105,8 @@ func (br BannerRoutes) SignBanner(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		captchaVerified := verifyCaptcha(solution)
+		captchaVerified := verifyCaptcha(r.Context(), br.App.HTTPClient, br.App.Config, solution)
+		metrics.ObserveCaptcha(captchaVerified)
 		if !captchaVerified {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(models.CreateError("Captcha verification failed"))
@@ -113,51 +122,40 @@ func (br BannerRoutes) SignBanner(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if ref != nil {
-		var count int64
-		db.Raw(`
-			SELECT 1
-			FROM signatures
-			WHERE user_id = ?
-			LIMIT 1
-		`, ref).
-			Count(&count)
-
-		if count == 0 {
-			ref = nil
+	// the referrer having signed is checked in the same transaction as the insert
+	err = br.App.Signatures.CreateSignature(r.Context(), &sig)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadySigned) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(models.CreateError("You have already signed the banner"))
+			return
 		}
-	}
 
-	res := db.Create(&sig)
-	if res.Error != nil {
-		var e *pgconn.PgError
-		if errors.As(res.Error, &e) {
-			if e.Code == "23505" {
-				w.WriteHeader(http.StatusUnprocessableEntity)
-				w.Write(models.CreateError("You have already signed the banner"))
-				return
-			}
+		if errors.Is(err, database.ErrSignatureRemoved) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(models.CreateError("Your signature was removed by a moderator"))
+			return
 		}
 
-		log.Printf("Failed to create signature: %v\n", res.Error)
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to create signature")
 
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	metrics.SignaturesCreated.Inc()
+	recordAudit(br.App.Audit, r, userId, database.AUDIT_SIGNATURE_CREATE, userId, map[string]interface{}{
+		"referrer_id": sig.ReferrerID,
+	})
+
 	bytes, err := json.Marshal(sig)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	pos, err := database.GetUserPosition(db, userId)
-	if err != nil {
-		log.Printf("failed to get user position for feed: %v\n", err)
-	}
-
-	sendSignatureFeedMessage(user, pos)
-	addSignatureRoleToUser(user)
+	sendSignatureFeedMessage(r.Context(), br.App.Discord, br.App.Config, user, *sig.Position)
+	addSignatureRoleToUser(r.Context(), br.App.Discord, br.App.Config, user)
 
 	w.Header().Add("Content-Type", "application/json")
 	w.Write(bytes)
@@ -167,32 +165,42 @@ func (br BannerRoutes) UnsignBanner(w http.ResponseWriter, r *http.Request) {
 	session := r.Context().Value("session").(*auth.Session)
 	userId := session.UserID
 
-	db := database.GetDatabase()
+	deleted, err := br.App.Signatures.DeleteSignature(r.Context(), userId)
+	if errors.Is(err, database.ErrSignatureRemoved) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(models.CreateError("Your signature was removed by a moderator"))
+		return
+	}
 
-	res := db.Where("user_id = ?", userId).Unscoped().Delete(&database.Signature{})
-	if res.Error != nil {
-		fmt.Printf("failed to delete from database: %v\n", res.Error)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to delete from database")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	if deleted {
+		metrics.SignaturesDeleted.WithLabelValues(metrics.DELETE_REASON_UNSIGNED).Inc()
+		recordAudit(br.App.Audit, r, userId, database.AUDIT_SIGNATURE_DELETE, userId, nil)
+	}
 }
 
-func verifyCaptcha(sol string) bool {
-	secret := os.Getenv("CAPTCHA_SECRET")
-	verifyUrl := os.Getenv("CAPTCHA_VERIFY_URL")
+func verifyCaptcha(ctx context.Context, client *http.Client, conf *config.Config, sol string) bool {
+	secret := conf.CaptchaSecret
+	verifyUrl := conf.CaptchaVerifyURL
 
 	pl := url.Values{}
 
 	pl.Set("response", sol)
 	pl.Set("secret", secret)
 
-	req, _ := http.NewRequest("POST", verifyUrl, strings.NewReader(pl.Encode()))
+	req, _ := http.NewRequestWithContext(ctx, "POST", verifyUrl, strings.NewReader(pl.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("failed to verify captcha solution: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to verify captcha solution")
 		return false
 	}
+	defer resp.Body.Close()
 
 	bdy, _ := io.ReadAll(resp.Body)
 
@@ -203,7 +211,7 @@ func verifyCaptcha(sol string) bool {
 
 	err = json.Unmarshal(bdy, &body)
 	if err != nil {
-		fmt.Printf("failed to parse JSON response: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to parse JSON response")
 		return false
 	}
 
@@ -211,16 +219,16 @@ func verifyCaptcha(sol string) bool {
 		return true
 	}
 
-	fmt.Printf("hcaptcha responded with errors: %v\n", body.ErrorCodes)
+	logging.Ctx(ctx).Warn().Strs("error_codes", body.ErrorCodes).Msg("hcaptcha responded with errors")
 	return false
 }
 
 func (br BannerRoutes) GenerateBanner(w http.ResponseWriter, r *http.Request) {
-	bannerCache := cache.GetBannerCache()
+	bannerCache := br.App.BannerCache
 
-	b, shouldRegen, err := bannerCache.Get()
+	b, shouldRegen, err := bannerCache.Get(r.Context())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to read banner image from cache: %v\n", err)
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to read banner image from cache")
 	}
 
 	if b != nil {
@@ -235,18 +243,24 @@ func (br BannerRoutes) GenerateBanner(w http.ResponseWriter, r *http.Request) {
 	var genError error
 
 	if shouldRegen {
-		regend, genError = br.bannerGenClient.GenerateBanner(
-			context.Background(),
+		start := time.Now()
+		regend, genError = br.App.BannerClient.GenerateBanner(
+			r.Context(),
 			&protos.CreateBannerRequest{},
 		)
 
+		metrics.BannerGenerateDuration.Observe(time.Since(start).Seconds())
+		if genError != nil {
+			metrics.BannerGenerateErrors.Inc()
+		}
+
 		if regend != nil && genError == nil {
-			bannerCache.Set(regend)
+			bannerCache.Set(r.Context(), regend)
 		}
 	}
 
 	if genError != nil {
-		fmt.Fprintf(os.Stderr, "failed to generate banner: %v\n", err)
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to generate banner")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -264,9 +278,9 @@ func (br BannerRoutes) GenerateBanner(w http.ResponseWriter, r *http.Request) {
 // 	signatures := []string{}
 // }
 
-func sendSignatureFeedMessage(user *models.DiscordUser, position int64) {
-	webhook, exists := os.LookupEnv("SIGNATURE_FEED_WEBHOOK")
-	if !exists {
+func sendSignatureFeedMessage(ctx context.Context, discord *models.Discord, conf *config.Config, user *models.DiscordUser, position int64) {
+	webhook := conf.SignatureFeedWebhook
+	if webhook == "" {
 		return
 	}
 
@@ -283,26 +297,38 @@ func sendSignatureFeedMessage(user *models.DiscordUser, position int64) {
 	}
 
 	j, _ := json.Marshal(&postBody)
-	_, err := http.Post(webhook, "application/json", bytes.NewBuffer(j))
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewBuffer(j))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to post feed message: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to create feed message request")
 		return
 	}
-}
 
-func addSignatureRoleToUser(user *models.DiscordUser) {
-	discordToken, discordTokenExists := os.LookupEnv("DISCORD_TOKEN")
-	signatureRole, signatureRoleExists := os.LookupEnv("SIGNATURE_ROLE")
-	guildID, guildIDExists := os.LookupEnv("SIGNATURE_ROLE_GUILD_ID")
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := discord.HTTPClient.Do(req)
+	metrics.ObserveDiscordRequest(metrics.DISCORD_FEED_WEBHOOK, res, err)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to post feed message")
+		return
+	}
+	defer res.Body.Close()
+}
 
-	if !discordTokenExists || !signatureRoleExists || !guildIDExists {
+func addSignatureRoleToUser(ctx context.Context, discord *models.Discord, conf *config.Config, user *models.DiscordUser) {
+	if !conf.AssignsSignatureRole() {
 		return
 	}
 
-	req, err := http.NewRequest(
+	discordToken := conf.DiscordToken
+	signatureRole := conf.SignatureRole
+	guildID := conf.SignatureRoleGuildID
+
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"PUT",
 		fmt.Sprintf(
-			"https://discord.com/api/v10/guilds/%s/members/%s/roles/%s",
+			"%s/v10/guilds/%s/members/%s/roles/%s",
+			discord.APIURL,
 			guildID,
 			user.ID,
 			signatureRole,
@@ -311,16 +337,18 @@ func addSignatureRoleToUser(user *models.DiscordUser) {
 	)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to create PUT /guilds/:guild/members/:member/roles/:role request: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to create PUT /guilds/:guild/members/:member/roles/:role request")
 		return
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bot "+discordToken)
 
-	_, err = http.DefaultClient.Do(req)
+	res, err := discord.HTTPClient.Do(req)
+	metrics.ObserveDiscordRequest(metrics.DISCORD_ADD_ROLE, res, err)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to add role to user: %v\n", err)
+		logging.Ctx(ctx).Error().Err(err).Msg("failed to add role to user")
 		return
 	}
+	defer res.Body.Close()
 }
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+)
+
+// HealthRoutes are polled by the orchestrator. They are registered on the
+// root router, see NewRouter.
+type HealthRoutes struct {
+	App *app.App
+}
+
+type (
+	DependencyStatus struct {
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	ReadinessPayload struct {
+		Status       string                      `json:"status"`
+		Dependencies map[string]DependencyStatus `json:"dependencies"`
+	}
+)
+
+// Healthz reports that the process is up, without looking at dependencies so
+// an outage of one of them doesn't get every instance restarted.
+func (hr HealthRoutes) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// Readyz reports whether the instance can serve traffic, with the state of
+// every dependency. It fails while the server is shutting down.
+func (hr HealthRoutes) Readyz(w http.ResponseWriter, r *http.Request) {
+	pl := ReadinessPayload{
+		Status:       "ok",
+		Dependencies: map[string]DependencyStatus{},
+	}
+
+	for name, err := range hr.App.CheckDependencies(r.Context()) {
+		if err != nil {
+			pl.Status = "unavailable"
+			pl.Dependencies[name] = DependencyStatus{
+				Status: "error",
+				Error:  err.Error(),
+			}
+		} else {
+			pl.Dependencies[name] = DependencyStatus{
+				Status: "ok",
+			}
+		}
+	}
+
+	if hr.App.ShuttingDown() {
+		pl.Status = "shutting_down"
+	}
+
+	b, err := json.Marshal(pl)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if pl.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	w.Write(b)
+}
@@ -0,0 +1,196 @@
+package routes
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+)
+
+// bannerServer starts a grpc server for the banner connection to reach.
+func bannerServer(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+// silentServer accepts connections but never answers, so grpc never gets past
+// connecting to it.
+func silentServer(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	return lis.Addr().String()
+}
+
+func dialBanner(t *testing.T, ta *testApp, addr string) {
+	t.Helper()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	ta.BannerConn = conn
+}
+
+func TestHealthz(t *testing.T) {
+	ta := newTestApp(t)
+
+	// it doesn't look at dependencies (there is no banner connection at all)
+	// or at shutdown
+	ta.BeginShutdown()
+
+	w := ta.do(t, "", "GET", "/healthz", "")
+	assertStatus(t, w, http.StatusOK)
+
+	if body := strings.TrimSpace(w.Body.String()); body != `{"status":"ok"}` {
+		t.Errorf("got %s", body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, ta *testApp)
+		want  int
+		// status of every dependency, checked per name
+		status       string
+		dependencies map[string]string
+	}{
+		{
+			name: "healthy",
+			setup: func(t *testing.T, ta *testApp) {
+				dialBanner(t, ta, bannerServer(t))
+			},
+			want:         http.StatusOK,
+			status:       "ok",
+			dependencies: map[string]string{"redis": "ok", "banner_grpc": "ok"},
+		},
+		{
+			name: "redis failing",
+			setup: func(t *testing.T, ta *testApp) {
+				dialBanner(t, ta, bannerServer(t))
+
+				mr := miniredis.RunT(t)
+				mr.SetError("LOADING redis is loading the dataset in memory")
+				ta.Redis = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+			},
+			want:         http.StatusServiceUnavailable,
+			status:       "unavailable",
+			dependencies: map[string]string{"redis": "error", "banner_grpc": "ok"},
+		},
+		{
+			name: "banner generator down",
+			setup: func(t *testing.T, ta *testApp) {
+				lis, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// nothing listens there anymore
+				addr := lis.Addr().String()
+				lis.Close()
+
+				dialBanner(t, ta, addr)
+			},
+			want:         http.StatusServiceUnavailable,
+			status:       "unavailable",
+			dependencies: map[string]string{"redis": "ok", "banner_grpc": "error"},
+		},
+		{
+			name: "shutting down",
+			setup: func(t *testing.T, ta *testApp) {
+				dialBanner(t, ta, bannerServer(t))
+				ta.BeginShutdown()
+			},
+			want:         http.StatusServiceUnavailable,
+			status:       "shutting_down",
+			dependencies: map[string]string{"redis": "ok", "banner_grpc": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t)
+			tt.setup(t, ta)
+
+			w := ta.do(t, "", "GET", "/readyz", "")
+			assertStatus(t, w, tt.want)
+
+			var pl ReadinessPayload
+			decode(t, w, &pl)
+
+			if pl.Status != tt.status {
+				t.Errorf("got status %q, want %q", pl.Status, tt.status)
+			}
+
+			// the memory store has nothing to check
+			if len(pl.Dependencies) != len(tt.dependencies) {
+				t.Errorf("got dependencies %+v, want %v", pl.Dependencies, tt.dependencies)
+			}
+
+			for name, status := range tt.dependencies {
+				dep := pl.Dependencies[name]
+				if dep.Status != status || (status == "error") != (dep.Error != "") {
+					t.Errorf("%v = %+v, want %v", name, dep, status)
+				}
+			}
+		})
+	}
+}
+
+// A dependency that hangs fails its check after READINESS_CHECK_TIMEOUT
+// without holding up the others.
+func TestCheckDependenciesTimeout(t *testing.T) {
+	ta := newTestApp(t)
+	dialBanner(t, ta, silentServer(t))
+
+	start := time.Now()
+	results := ta.CheckDependencies(context.Background())
+	took := time.Since(start)
+
+	if results["banner_grpc"] == nil || results["redis"] != nil {
+		t.Errorf("got results %v, want only the banner generator failing", results)
+	}
+
+	if took < app.READINESS_CHECK_TIMEOUT || took > app.READINESS_CHECK_TIMEOUT+time.Second {
+		t.Errorf("checks took %v, want about %v", took, app.READINESS_CHECK_TIMEOUT)
+	}
+}
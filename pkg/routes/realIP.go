@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces the request's RemoteAddr with the client IP forwarded by a
+// trusted proxy. X-Forwarded-For and X-Real-IP are ignored on requests that
+// don't come from one, otherwise any client could pick the IP shown in the
+// session list and the audit log.
+func RealIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the client IP forwarded to us, or "" if the request
+// didn't come from a trusted proxy or forwarded nothing usable.
+func forwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !isTrusted(net.ParseIP(host), trusted) {
+		return ""
+	}
+
+	// every proxy appends the address it got the request from, so the first
+	// untrusted address from the right is the client. Anything before it was
+	// sent by the client and can't be believed.
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) != 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+
+			client = ip.String()
+			if !isTrusted(ip, trusted) {
+				break
+			}
+		}
+
+		return client
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
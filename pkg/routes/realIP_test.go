@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		xRealIP    string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:4321",
+			want:       "203.0.113.7:4321",
+		},
+		{
+			name:       "spoofed forwarded for from untrusted client",
+			remoteAddr: "203.0.113.7:4321",
+			xff:        "198.51.100.1",
+			want:       "203.0.113.7:4321",
+		},
+		{
+			name:       "spoofed real ip from untrusted client",
+			remoteAddr: "203.0.113.7:4321",
+			xRealIP:    "198.51.100.1",
+			want:       "203.0.113.7:4321",
+		},
+		{
+			name:       "forwarded by trusted proxy",
+			remoteAddr: "10.0.0.2:80",
+			xff:        "198.51.100.1",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "client prepends a fake hop",
+			remoteAddr: "10.0.0.2:80",
+			xff:        "192.0.2.99, 198.51.100.1",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.2:80",
+			xff:        "198.51.100.1, 10.0.0.5, 10.0.0.3",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "real ip from trusted proxy",
+			remoteAddr: "10.0.0.2:80",
+			xRealIP:    "198.51.100.1",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "garbage from trusted proxy",
+			remoteAddr: "10.0.0.2:80",
+			xff:        "not an ip",
+			want:       "10.0.0.2:80",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/cors"
+	"github.com/go-chi/httprate"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/metrics"
+	"github.com/thankyoudiscord/api/pkg/tracing"
+)
+
+// NewRouter builds the API's router on top of the app's dependencies.
+func NewRouter(a *app.App) chi.Router {
+	// checked when the config is loaded
+	trustedProxies, _ := a.Config.TrustedProxyNetworks()
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(RealIP(trustedProxies))
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware(a.Logger))
+	r.Use(metrics.Middleware)
+
+	// outside the group so probes aren't rate limited or logged
+	health := HealthRoutes{App: a}
+	r.Get("/healthz", health.Healthz)
+	r.Get("/readyz", health.Readyz)
+
+	r.Group(func(r chi.Router) {
+		r.Use(logging.AccessLog)
+
+		r.Use(httprate.Limit(
+			15,
+			10*time.Second,
+			httprate.WithKeyFuncs(httprate.KeyByEndpoint, httprate.KeyByIP),
+		))
+
+		allowedOrigins := []string{"*"}
+		if a.Config.IsProduction() {
+			allowedOrigins = []string{
+				"localhost:*",
+				"*.wah.wtf",
+				"*.thankyoudiscord.com",
+			}
+		}
+
+		r.Use(cors.Handler(cors.Options{
+			AllowedOrigins:   allowedOrigins,
+			AllowCredentials: true,
+		}))
+
+		r.Mount("/", AuthRoutes{App: a}.Routes())
+
+		r.Mount("/banner", NewBannerRoutes(a).Routes())
+		r.Mount("/users", UserRoutes{App: a}.Routes())
+		r.Mount("/sessions", SessionRoutes{App: a}.Routes())
+		r.Mount("/tokens", TokenRoutes{App: a}.Routes())
+		r.Mount("/admin", AdminRoutes{App: a}.Routes())
+
+		r.Get("/stats", func(w http.ResponseWriter, r *http.Request) {
+			count, err := a.Signatures.CountSignatures(r.Context())
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			resp := map[string]int64{
+				"signatures": count,
+			}
+
+			bytes, err := json.Marshal(resp)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Write(bytes)
+		})
+
+	})
+
+	return r
+}
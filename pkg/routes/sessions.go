@@ -0,0 +1,111 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+type SessionRoutes struct {
+	App *app.App
+}
+
+func (sr SessionRoutes) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(sr.App.Auth.Authenticated)
+	r.Use(auth.RequireSession)
+
+	r.Get("/", sr.ListSessions)
+	r.Delete("/", sr.DeleteAllSessions)
+	r.Delete("/{id}", sr.DeleteSession)
+
+	return r
+}
+
+type SessionPayload struct {
+	auth.SessionInfo
+	Current bool `json:"current"`
+}
+
+func (sr SessionRoutes) ListSessions(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+	sessionId := r.Context().Value("session_id").(string)
+
+	sessions, err := sr.App.Auth.ListSessions(r.Context(), session.UserID)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to list sessions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	currentID := auth.PublicSessionID(sessionId)
+
+	pl := make([]SessionPayload, len(sessions))
+	for i, s := range sessions {
+		pl[i] = SessionPayload{
+			SessionInfo: s,
+			Current:     s.ID == currentID,
+		}
+	}
+
+	b, err := json.Marshal(pl)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (sr SessionRoutes) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+	publicID := chi.URLParam(r, "id")
+
+	found, err := sr.App.Auth.DeleteSessionByPublicID(r.Context(), session.UserID, publicID)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to delete session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(models.CreateError("Session not found"))
+		return
+	}
+
+	recordAudit(sr.App.Audit, r, session.UserID, database.AUDIT_SESSION_REVOKE, session.UserID, map[string]interface{}{
+		"session": publicID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sr SessionRoutes) DeleteAllSessions(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+
+	err := sr.App.Auth.DeleteUserSessions(r.Context(), session.UserID)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to delete sessions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(sr.App.Audit, r, session.UserID, database.AUDIT_SESSIONS_REVOKE, session.UserID, nil)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   auth.SESSION_ID_COOKIE,
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
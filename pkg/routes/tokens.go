@@ -0,0 +1,168 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/thankyoudiscord/api/pkg/app"
+	"github.com/thankyoudiscord/api/pkg/auth"
+	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
+	"github.com/thankyoudiscord/api/pkg/models"
+)
+
+type TokenRoutes struct {
+	App *app.App
+}
+
+func (tr TokenRoutes) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(tr.App.Auth.Authenticated)
+	r.Use(auth.RequireSession)
+
+	r.Get("/", tr.ListTokens)
+	r.Post("/", tr.CreateToken)
+	r.Delete("/{id}", tr.RevokeToken)
+
+	return r
+}
+
+type (
+	CreateTokenPayload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	TokenPayload struct {
+		database.APIToken
+		Scopes []string `json:"scopes"`
+	}
+
+	CreatedTokenPayload struct {
+		TokenPayload
+		Token string `json:"token"`
+	}
+)
+
+func (tr TokenRoutes) ListTokens(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+
+	tokens, err := tr.App.Auth.ListAPITokens(r.Context(), session.UserID)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to list api tokens")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	pl := make([]TokenPayload, len(tokens))
+	for i, t := range tokens {
+		pl[i] = TokenPayload{
+			APIToken: t,
+			Scopes:   t.ScopeList(),
+		}
+	}
+
+	b, err := json.Marshal(pl)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (tr TokenRoutes) CreateToken(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+
+	var body CreateTokenPayload
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("Failed to parse JSON payload"))
+		return
+	}
+
+	if len(body.Name) == 0 || len(body.Scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(models.CreateError("A token needs a name and at least one scope"))
+		return
+	}
+
+	token, record, err := tr.App.Auth.CreateAPIToken(r.Context(), session.UserID, body.Name, body.Scopes, body.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownScope) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError("Unknown scope"))
+			return
+		}
+
+		if errors.Is(err, auth.ErrExpiryInPast) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(models.CreateError("expires_at must be in the future"))
+			return
+		}
+
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to create api token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(tr.App.Audit, r, session.UserID, database.AUDIT_TOKEN_CREATE, session.UserID, map[string]interface{}{
+		"token_id": record.ID,
+		"name":     record.Name,
+		"scopes":   record.ScopeList(),
+	})
+
+	b, err := json.Marshal(CreatedTokenPayload{
+		TokenPayload: TokenPayload{
+			APIToken: *record,
+			Scopes:   record.ScopeList(),
+		},
+		Token: token,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+func (tr TokenRoutes) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(*auth.Session)
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(models.CreateError("Token not found"))
+		return
+	}
+
+	found, err := tr.App.Auth.RevokeAPIToken(r.Context(), session.UserID, uint(id))
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to revoke api token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(models.CreateError("Token not found"))
+		return
+	}
+
+	recordAudit(tr.App.Audit, r, session.UserID, database.AUDIT_TOKEN_REVOKE, session.UserID, map[string]interface{}{
+		"token_id": id,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thankyoudiscord/api/pkg/auth"
+)
+
+func TestCreateToken(t *testing.T) {
+	ta := newTestApp(t)
+	cookie := ta.login(t, SIGNER_ID)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/tokens", strings.NewReader(body))
+		req.AddCookie(cookie)
+
+		w := httptest.NewRecorder()
+		ta.router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	past := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no scopes", `{"name":"ci"}`, http.StatusBadRequest},
+		{"unknown scope", `{"name":"ci","scopes":["everything"]}`, http.StatusBadRequest},
+		{"expired", `{"name":"ci","scopes":["user:read"],"expires_at":"` + past + `"}`, http.StatusBadRequest},
+		{"expiring", `{"name":"ci","scopes":["user:read"],"expires_at":"` + future + `"}`, http.StatusCreated},
+		{"never expiring", `{"name":"ci","scopes":["user:read"]}`, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertStatus(t, create(tt.body), tt.want)
+		})
+	}
+
+	var created CreatedTokenPayload
+	decode(t, create(`{"name":"ci","scopes":["user:read"]}`), &created)
+	if !strings.HasPrefix(created.Token, auth.API_TOKEN_PREFIX) {
+		t.Fatalf("got token %q", created.Token)
+	}
+
+	// tokens can't manage tokens
+	ta.tokens[SIGNER_ID] = created.Token
+	assertStatus(t, ta.do(t, SIGNER_ID, "GET", "/tokens", ""), http.StatusForbidden)
+}
+
+func TestUserRoutesAPIToken(t *testing.T) {
+	ta := newTestApp(t)
+	ctx := context.Background()
+
+	for name, scopes := range map[string][]string{
+		"read":      {auth.SCOPE_USER_READ},
+		"sign only": {auth.SCOPE_SIGNATURE_WRITE},
+		"revoked":   {auth.SCOPE_USER_READ},
+	} {
+		token, _, err := ta.Auth.CreateAPIToken(ctx, SIGNER_ID, name, scopes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ta.tokens[name] = token
+	}
+
+	tokens, err := ta.Auth.ListAPITokens(ctx, SIGNER_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range tokens {
+		if token.Name == "revoked" {
+			if _, err := ta.Auth.RevokeAPIToken(ctx, SIGNER_ID, token.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// expiring in a moment, past expiries can't be created
+	token, _, err := ta.Auth.CreateAPIToken(ctx, SIGNER_ID, "expired", []string{auth.SCOPE_USER_READ}, timePtr(time.Now().Add(50*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta.tokens["expired"] = token
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"read", http.StatusOK},
+		{"sign only", http.StatusForbidden},
+		{"revoked", http.StatusUnauthorized},
+		{"expired", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			assertStatus(t, ta.do(t, tt.token, "GET", "/users/@me", ""), tt.want)
+		})
+	}
+
+	var pl GetUserPayload
+	decode(t, ta.do(t, "read", "GET", "/users/@me", ""), &pl)
+	if pl.User.ID != SIGNER_ID || !pl.Signature.HasSigned || pl.Signature.Position != 1 {
+		t.Errorf("got %+v", pl)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
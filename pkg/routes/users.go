@@ -2,24 +2,25 @@ package routes
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi"
-	"gorm.io/gorm"
 
+	"github.com/thankyoudiscord/api/pkg/app"
 	"github.com/thankyoudiscord/api/pkg/auth"
-	"github.com/thankyoudiscord/api/pkg/database"
+	"github.com/thankyoudiscord/api/pkg/logging"
 	"github.com/thankyoudiscord/api/pkg/models"
 )
 
-type UserRoutes struct{}
+type UserRoutes struct {
+	App *app.App
+}
 
 func (ur UserRoutes) Routes() chi.Router {
 	r := chi.NewRouter()
-	r.Use(auth.Authenticated)
+	r.Use(ur.App.Auth.Authenticated)
 
-	r.Get("/@me", ur.GetSelf)
+	r.With(auth.RequireScope(auth.SCOPE_USER_READ)).Get("/@me", ur.GetSelf)
 
 	return r
 }
@@ -44,49 +45,38 @@ func (ur UserRoutes) GetSelf(w http.ResponseWriter, r *http.Request) {
 
 	data, ok := r.Context().Value("user").(*models.DiscordUser)
 	if !ok {
-		fmt.Println("failed to read \"user\" from request context")
+		logging.Ctx(r.Context()).Error().Msg("failed to read \"user\" from request context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	db := database.GetDatabase()
-
-	hasSigned := false
-
-	sig := database.Signature{}
-	res := db.Where("user_id = ?", userId).Find(&sig)
-	if res.Error != nil {
-		if res.Error == gorm.ErrRecordNotFound {
-			hasSigned = false
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// TODO: is there a better way to check if the record exists?
-	if !sig.CreatedAt.IsZero() {
-		hasSigned = true
+	sig, err := ur.App.Signatures.GetSignature(r.Context(), userId)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to get signature")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	var count int64 = 0
-	re := db.Model(&database.Signature{}).Where("referrer_id = ?", userId).Count(&count)
-	if re.Error != nil {
-		fmt.Printf("failed to count refs: %v\n", res.Error)
+	count, err := ur.App.Signatures.CountReferrals(r.Context(), userId)
+	if err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("failed to count refs")
 		count = 0
 	}
 
 	pl := GetUserPayload{
 		User: *data,
 		Signature: GetUserPayloadSignature{
-			HasSigned:     hasSigned,
+			HasSigned:     sig != nil,
 			ReferralCount: count,
-			ReferredBy:    sig.ReferrerID,
 		},
 	}
 
-	position, _ := database.GetUserPosition(db, userId)
-	pl.Signature.Position = position
+	if sig != nil {
+		pl.Signature.ReferredBy = sig.ReferrerID
+		if sig.Position != nil {
+			pl.Signature.Position = *sig.Position
+		}
+	}
 
 	b, err := json.Marshal(pl)
 	if err != nil {
@@ -0,0 +1,35 @@
+package tracing
+
+import "testing"
+
+func TestFirstWord(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"gorm statement", `SELECT * FROM "users" WHERE user_id = $1`, "SELECT"},
+		{"single word", "COMMIT", "COMMIT"},
+		{"empty", "", ""},
+		{"only whitespace", " \n\t ", ""},
+		{
+			name: "backtick query",
+			sql: `
+		UPDATE signature_position_counter
+		SET last_position = last_position + 1
+		WHERE id = 1
+		RETURNING last_position
+	`,
+			want: "UPDATE",
+		},
+		{"leading tab", "\tDELETE FROM signatures", "DELETE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstWord(tt.sql); got != tt.want {
+				t.Errorf("firstWord(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}